@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/queue"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+var (
+	// Dead letters queued to be replayed, so the same one is not replayed twice at the same time
+	replaying      = make(map[string]bool)
+	replayingMutex sync.Mutex
+)
+
+// deadLetterSummary is a dead letter without the payload and the headers
+type deadLetterSummary struct {
+	ID         string     `json:"id"`
+	Provider   string     `json:"provider"`
+	Event      string     `json:"event"`
+	DeliveryId string     `json:"deliveryId,omitempty"`
+	Url        string     `json:"url"`
+	ReceivedAt time.Time  `json:"receivedAt"`
+	FailedAt   *time.Time `json:"failedAt,omitempty"`
+	Reason     string     `json:"reason"`
+}
+
+// registerAdminApi adds the endpoints to list and replay the dead letters. All of them require
+// the header "Authorization: Bearer <token>"
+func registerAdminApi(r *mux.Router, token string, providers []webhook.Provider) {
+	byName := make(map[string]webhook.Provider)
+
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+
+	admin := r.PathPrefix("/admin").Subrouter()
+
+	admin.Use(adminAuth(token))
+
+	admin.HandleFunc("/deliveries", listDeadLetters).Methods("GET")
+	admin.HandleFunc("/deliveries/{id}", getDeadLetter).Methods("GET")
+	admin.HandleFunc("/deliveries/{id}/replay", replayDeadLetter(byName)).Methods("POST")
+}
+
+// adminAuth rejects the requests without the token
+func adminAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintf(w, "unauthorized")
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// listDeadLetters responds with the dead letters, from oldest to newest
+func listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	records, err := deadLetters.List()
+
+	if err != nil {
+		// Note: The records that can be read are listed anyway
+		utils.Log("ERROR", fmt.Sprintf("unable to read dead letters: %s", err.Error()))
+	}
+
+	if records == nil && err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "some internal error ocurred")
+
+		return
+	}
+
+	summaries := make([]*deadLetterSummary, 0, len(records))
+
+	for _, record := range records {
+		summaries = append(summaries, &deadLetterSummary{
+			ID:         record.ID,
+			Provider:   record.Provider,
+			Event:      record.Event,
+			DeliveryId: record.DeliveryId,
+			Url:        record.Url,
+			ReceivedAt: record.ReceivedAt,
+			FailedAt:   record.FailedAt,
+			Reason:     record.Reason,
+		})
+	}
+
+	writeJson(w, http.StatusOK, summaries)
+}
+
+// getDeadLetter responds with the dead letter, including the payload and the headers without the
+// credentials
+func getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	record := findDeadLetter(w, mux.Vars(r)["id"])
+
+	if record == nil {
+		return
+	}
+
+	// Note: The dead letters are saved without the credentials, but the files may have been
+	// written by a previous version
+	record.Header = queue.RedactHeader(record.Header)
+
+	writeJson(w, http.StatusOK, record)
+}
+
+// replayDeadLetter queues the dead letter to be processed again by the workers and responds 202.
+// It is removed if it succeeds, otherwise it is kept with the new reason
+//
+// Note: The outcome is logged, it is not awaited because the PipelineRun may be retried for longer
+// than the timeout of the server
+func replayDeadLetter(providers map[string]webhook.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record := findDeadLetter(w, mux.Vars(r)["id"])
+
+		if record == nil {
+			return
+		}
+
+		ctx := utils.WithLogField(context.Background(), utils.LogFieldRequestId, record.ID)
+
+		req, err := requestFromRecord(ctx, record, providers)
+
+		if err != nil {
+			utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to replay dead letter: %s", err.Error()))
+
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, "unable to replay dead letter: %s", err.Error())
+
+			return
+		}
+
+		if !startReplay(record.ID) {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, "dead letter %s is already being replayed", record.ID)
+
+			return
+		}
+
+		queued := workers.Submit(func() {
+			defer endReplay(record.ID)
+
+			utils.LogContext(ctx, "INFO", "replaying dead letter")
+
+			result := processRequest(ctx, req)
+
+			if result.Failed {
+				return
+			}
+
+			err := deadLetters.Done(record.ID)
+
+			if err != nil {
+				utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to remove dead letter: %s", err.Error()))
+			}
+		})
+
+		if !queued {
+			endReplay(record.ID)
+			queueFull(ctx, w)
+
+			return
+		}
+
+		writeJson(w, http.StatusAccepted, &webhook.Result{
+			ID:      record.ID,
+			Status:  http.StatusAccepted,
+			Message: fmt.Sprintf("queued replay of dead letter %s", record.ID),
+		})
+	}
+}
+
+// startReplay marks the dead letter as being replayed. It returns false if it already is
+func startReplay(id string) bool {
+	replayingMutex.Lock()
+	defer replayingMutex.Unlock()
+
+	if replaying[id] {
+		return false
+	}
+
+	replaying[id] = true
+
+	return true
+}
+
+func endReplay(id string) {
+	replayingMutex.Lock()
+	defer replayingMutex.Unlock()
+
+	delete(replaying, id)
+}
+
+// findDeadLetter returns the dead letter, or responds 404 and returns nil if it does not exist
+func findDeadLetter(w http.ResponseWriter, id string) *queue.Record {
+	record, err := deadLetters.Get(id)
+
+	if err != nil {
+		utils.Log("ERROR", fmt.Sprintf("unable to read dead letter %s: %s", id, err.Error()))
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "some internal error ocurred")
+
+		return nil
+	}
+
+	if record == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "dead letter %s not found", id)
+
+		return nil
+	}
+
+	return record
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.Marshal(v)
+
+	if err != nil {
+		utils.Log("ERROR", err.Error())
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "some internal error ocurred")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
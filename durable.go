@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/queue"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+const (
+	// Time to wait for a free slot in the queue when replaying the requests
+	replayRetryInterval time.Duration = time.Second
+
+	defaultMaxDeadLetters int = 1000
+)
+
+var (
+	// Requests accepted but not processed yet. It is nil if QUEUE_DIR is not set
+	store *queue.Store
+
+	// Requests that failed. It is nil if neither DEAD_LETTER_DIR nor QUEUE_DIR are set
+	deadLetters *queue.Store
+
+	// Maximum number of dead letters kept
+	maxDeadLetters int = defaultMaxDeadLetters
+)
+
+// newRecord returns the request as it is saved in the stores
+func newRecord(req *webhook.Request) *queue.Record {
+	r := req.HttpRequest
+
+	return &queue.Record{
+		ID:         req.ID,
+		Provider:   req.Provider.Name(),
+		Method:     r.Method,
+		Url:        r.URL.RequestURI(),
+		Header:     r.Header,
+		Payload:    req.Payload,
+		Event:      req.Event,
+		DeliveryId: req.DeliveryId,
+		ReceivedAt: time.Now(),
+	}
+}
+
+// persistRequest saves the request before it is queued, so it is replayed if the listener stops
+// before processing it
+func persistRequest(req *webhook.Request) error {
+	if store == nil {
+		return nil
+	}
+
+	return store.Save(newRecord(req))
+}
+
+// forgetRequest removes the request from the store
+func forgetRequest(ctx context.Context, req *webhook.Request) {
+	if store == nil {
+		return
+	}
+
+	err := store.Done(req.ID)
+
+	if err != nil {
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to remove request from queue dir: %s", err.Error()))
+	}
+}
+
+// processRequest handles the request and removes it from the store. The requests that failed
+// (e.g. wrong signature or the PipelineRun could not be created after all the attempts) are
+// saved as dead letters
+//
+// Note: A request replayed after its PipelineRun was created is skipped as a redelivery if the
+// provider sends a delivery id
+func processRequest(ctx context.Context, req *webhook.Request) *webhook.Result {
+	result := req.HandleRequest(ctx)
+
+	if result.Failed {
+		saveDeadLetter(ctx, req, result)
+	}
+
+	forgetRequest(ctx, req)
+
+	return result
+}
+
+// saveDeadLetter saves the request that failed along with the reason (already logged)
+//
+// Note: The credentials are not saved (the requests in the queue dir keep them until they are
+// processed, their signature is not verified yet). Instead, the dead letter records whether the
+// signature was verified, so it can be replayed
+func saveDeadLetter(ctx context.Context, req *webhook.Request, result *webhook.Result) {
+	metrics.DeadLettered()
+
+	if deadLetters == nil {
+		return
+	}
+
+	record := newRecord(req)
+	now := time.Now()
+
+	record.Header = queue.RedactHeader(record.Header)
+	record.Reason = result.Message
+	record.FailedAt = &now
+	record.SignatureVerified = result.SignatureVerified
+
+	err := deadLetters.Save(record)
+
+	if err != nil {
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to save dead letter: %s", err.Error()))
+		return
+	}
+
+	utils.LogContext(ctx, "INFO", "request saved as dead letter")
+
+	// Note: The oldest ones are removed, the rejected requests (e.g. wrong signature) can be sent
+	// by anyone
+	removed, err := deadLetters.Trim(maxDeadLetters)
+
+	if err != nil {
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to remove old dead letters: %s", err.Error()))
+	}
+
+	if removed > 0 {
+		utils.LogContext(ctx, "WARNING", fmt.Sprintf("removed %d old dead letter(s), the limit is %d", removed, maxDeadLetters))
+	}
+}
+
+// replayRequests queues the requests of the store that were not processed before the listener
+// stopped. records must be listed before serving, otherwise the requests received meanwhile
+// would be processed twice
+func replayRequests(records []*queue.Record, providers []webhook.Provider) {
+	if len(records) == 0 {
+		return
+	}
+
+	utils.Log("INFO", fmt.Sprintf("replaying %d request(s) not processed", len(records)))
+
+	byName := make(map[string]webhook.Provider)
+
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+
+	for _, record := range records {
+		ctx := utils.WithLogField(context.Background(), utils.LogFieldRequestId, record.ID)
+		ctx = utils.WithLogField(ctx, utils.LogFieldProvider, record.Provider)
+		ctx = utils.WithLogField(ctx, utils.LogFieldDeliveryId, record.DeliveryId)
+		ctx = utils.WithLogField(ctx, utils.LogFieldEvent, record.Event)
+
+		req, err := requestFromRecord(ctx, record, byName)
+
+		if err != nil {
+			utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to replay request, discarding it: %s", err.Error()))
+
+			err = store.Done(record.ID)
+
+			if err != nil {
+				utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to remove request from queue dir: %s", err.Error()))
+			}
+
+			continue
+		}
+
+		utils.LogContext(ctx, "INFO", fmt.Sprintf("replaying request received at %s", record.ReceivedAt.Format("2006-01-02 15:04:05.000")))
+
+		// Note: Wait for a free slot instead of rejecting the request, there is nobody to retry it
+		for !workers.Submit(func() { processRequest(ctx, req) }) {
+			if isDraining() {
+				return
+			}
+
+			time.Sleep(replayRetryInterval)
+		}
+	}
+}
+
+// requestFromRecord rebuilds the request saved in the store
+func requestFromRecord(ctx context.Context, record *queue.Record, providers map[string]webhook.Provider) (*webhook.Request, error) {
+	provider, ok := providers[record.Provider]
+
+	if !ok {
+		return nil, fmt.Errorf("provider %s not found", record.Provider)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, record.Method, record.Url, bytes.NewReader(record.Payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header = record.Header
+
+	return &webhook.Request{
+		ID:                record.ID,
+		Provider:          provider,
+		HttpRequest:       r,
+		Payload:           record.Payload,
+		Event:             record.Event,
+		DeliveryId:        record.DeliveryId,
+		SignatureVerified: record.SignatureVerified,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getIntEnv returns the positive integer set in the environment variable name, or def if it
+// is not set
+func getIntEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s is not a positive integer", name, value)
+	}
+
+	return n, nil
+}
+
+// getDurationEnv returns the duration set in the environment variable name, or def if it
+// is not set
+func getDurationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", name, err.Error())
+	}
+
+	return d, nil
+}
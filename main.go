@@ -1,22 +1,47 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	bitbucketv1 "github.com/jaberchez/custom-tekton-listener/pkg/api/v1/bitbucket"
+	cloudeventsv1 "github.com/jaberchez/custom-tekton-listener/pkg/api/v1/cloudevents"
+	giteav1 "github.com/jaberchez/custom-tekton-listener/pkg/api/v1/gitea"
 	githubv1 "github.com/jaberchez/custom-tekton-listener/pkg/api/v1/github"
+	gitlabv1 "github.com/jaberchez/custom-tekton-listener/pkg/api/v1/gitlab"
+	"github.com/jaberchez/custom-tekton-listener/pkg/certs"
+	"github.com/jaberchez/custom-tekton-listener/pkg/commitstatus"
 	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/notify"
+	"github.com/jaberchez/custom-tekton-listener/pkg/queue"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tekton"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tracing"
 	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+	"github.com/jaberchez/custom-tekton-listener/pkg/watcher"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v2"
 )
 
 const (
-	listenPort string = "8080"
+	listenPort  string = "8080"
+	metricsPort string = "9090"
+
+	defaultPromoteInterval time.Duration = 10 * time.Second
+
+	// Seconds to wait before retrying when the queue is full
+	retryAfterSeconds int = 10
 )
 
 var (
@@ -39,15 +64,26 @@ func startupHealthCheck(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func gitHubListenerV1(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
+// webhookListenerV1 returns the handler for the endpoint of a provider
+func webhookListenerV1(provider webhook.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		// Note: The span continues the trace of the caller if it sends the header traceparent
+		ctx, span := tracing.StartHttp(r, fmt.Sprintf("webhook %s", provider.Name()))
+		defer span.End()
 
-	// Check source ip
-	if checkGithubIps {
-		sourceIp, err := utils.GetIpFromRequest(r)
+		// Fields of the logs of this request
+		//
+		// Note: The request carries the context, so the providers can log with the same fields
+		ctx = utils.WithLogField(ctx, utils.LogFieldProvider, provider.Name())
+		r = r.WithContext(ctx)
+
+		// Check source ip
+		allowed, err := provider.CheckSourceIp(r)
 
 		if err != nil {
-			utils.Log("ERROR", err.Error())
+			utils.LogContext(ctx, "ERROR", err.Error())
 
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "some internal error ocurred")
@@ -55,10 +91,20 @@ func gitHubListenerV1(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		allowed, err := utils.CheckGitHubIps(sourceIp)
+		if !allowed {
+			metrics.SourceIpRejected(provider.Name())
+
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "source ip not allowed")
+
+			return
+		}
+
+		// Get event from Header
+		event, err := provider.Event(r)
 
 		if err != nil {
-			utils.Log("ERROR", err.Error())
+			utils.LogContext(ctx, "ERROR", err.Error())
 
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "some internal error ocurred")
@@ -66,21 +112,128 @@ func gitHubListenerV1(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if !allowed {
-			utils.Log("ERROR", fmt.Sprintf("source IP %s not allowed", sourceIp))
+		// Note: It is read after the cheap checks, CloudEvents in structured mode parse the body
+		deliveryId := provider.DeliveryId(r)
 
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, "source ip not allowed")
+		ctx = utils.WithLogField(ctx, utils.LogFieldDeliveryId, deliveryId)
+		r = r.WithContext(ctx)
+
+		if provider.IsPing(event) {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, "pong at %s", time.Now().Format("2006-01-02 15:04:05.000"))
 
 			return
 		}
+
+		// Create unique id for this PipelineRun
+		id, err := utils.GenId()
+
+		if err != nil {
+			utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to create pipelinerun id: %s ", err.Error()))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "some internal error ocurred")
+
+			return
+		}
+
+		ctx = utils.WithLogField(ctx, utils.LogFieldRequestId, id)
+		ctx = utils.WithLogField(ctx, utils.LogFieldEvent, event)
+
+		span.SetAttributes(attribute.String("webhook.event", event), attribute.String("webhook.id", id))
+
+		req := &webhook.Request{
+			ID:          id,
+			Provider:    provider,
+			HttpRequest: r,
+			Event:       event,
+			DeliveryId:  deliveryId,
+		}
+
+		if strings.EqualFold(r.URL.Query().Get("dryRun"), "true") {
+			dryRunRequest(ctx, w, req)
+			return
+		}
+
+		// Check if the pipeline is configured to respond with the outcome of the request
+		pipelineConfig := webhook.GetPipelineFromRequest(r)
+
+		if pipelineConfig != nil && pipelineConfig.IsSync() {
+			syncRequest(ctx, w, req, pipelineConfig.GetSyncTimeout())
+			return
+		}
+
+		// Read body
+		req.Payload, err = ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			utils.LogContext(ctx, "ERROR", fmt.Sprintf("cannot read payload: %s", err.Error()))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "some internal error ocurred")
+
+			return
+		}
+
+		// Save the request before responding, so it is not lost if the listener stops
+		err = persistRequest(req)
+
+		if err != nil {
+			utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to persist request: %s", err.Error()))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "some internal error ocurred")
+
+			return
+		}
+
+		// Handle the request in background
+		//
+		// Note: The workers are drained when the server shuts down
+		bgCtx := utils.DetachContext(ctx)
+
+		queued := workers.Submit(func() {
+			// Process the request
+			processRequest(bgCtx, req)
+		})
+
+		if !queued {
+			// Note: The caller retries it
+			forgetRequest(ctx, req)
+			queueFull(ctx, w)
+
+			return
+		}
+
+		// We should respond as quickly as we can for timeout issues
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Queued request id %s at %s\n", id, time.Now().Format("2006-01-02 15:04:05.000"))
 	}
+}
 
-	// Get Github event from Header
-	githubEvent, err := getGithubEvent(r)
+// queueFull responds 503 to ask the caller to retry later
+func queueFull(ctx context.Context, w http.ResponseWriter) {
+	utils.LogContext(ctx, "WARNING", "request rejected, the queue is full")
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintf(w, "too many requests in progress, retry later")
+}
+
+// dryRunRequest processes the request synchronously without creating the PipelineRun, and
+// responds with the when results and the rendered PipelineRun
+func dryRunRequest(ctx context.Context, w http.ResponseWriter, req *webhook.Request) {
+	if !config.IsDryRunAllowed() {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "dry run is not allowed")
+
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.HttpRequest.Body)
 
 	if err != nil {
-		utils.Log("ERROR", err.Error())
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("cannot read payload: %s", err.Error()))
 
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "some internal error ocurred")
@@ -88,18 +241,32 @@ func gitHubListenerV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if githubEvent == "ping" {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "pong at %s", time.Now().Format("2006-01-02 15:04:05.000"))
+	req.Payload = body
+	req.DryRun = true
+
+	out, err := yaml.Marshal(req.HandleRequest(ctx))
+
+	if err != nil {
+		utils.LogContext(ctx, "ERROR", err.Error())
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "some internal error ocurred")
 
 		return
 	}
 
-	// Create unique id for this PipelineRun
-	id, err := utils.GenId()
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// syncRequest processes the request and responds with the outcome in JSON. If the request is not
+// processed before timeout, it responds 202 and the request continues in background
+func syncRequest(ctx context.Context, w http.ResponseWriter, req *webhook.Request, timeout time.Duration) {
+	body, err := ioutil.ReadAll(req.HttpRequest.Body)
 
 	if err != nil {
-		utils.Log("ERROR", fmt.Sprintf("unable to create pipelinerun id: %s ", err.Error()))
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("cannot read payload: %s", err.Error()))
 
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "some internal error ocurred")
@@ -107,52 +274,104 @@ func gitHubListenerV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set id for logs
-	utils.SetPipelineRunIdFieldLog(id)
+	req.Payload = body
 
-	// We should respond as quickly as we can for timeout issues
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Queued request id %s at %s\n", id, time.Now().Format("2006-01-02 15:04:05.000"))
-
-	// Read body
-	body, err := ioutil.ReadAll(r.Body)
+	// Save the request before responding, so it is not lost if the listener stops
+	err = persistRequest(req)
 
 	if err != nil {
-		utils.Log("ERROR", fmt.Sprintf("cannot read payload: %s", err.Error()))
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to persist request: %s", err.Error()))
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "some internal error ocurred")
+
 		return
 	}
 
-	// Handle the request in a go routine
-	go func() {
-		//utils.SetPipelineRunIdFieldLog(id)
+	resultCh := make(chan *webhook.Result, 1)
 
-		gitHub := &githubv1.GitHub{
-			ID:          id,
-			HttpRequest: r,
-			CheckIps:    checkGithubIps,
-			Payload:     body,
-			GithubEvent: githubEvent,
+	// Note: It is processed by the workers because it continues in background after timeout
+	bgCtx := utils.DetachContext(ctx)
+
+	queued := workers.Submit(func() {
+		resultCh <- processRequest(bgCtx, req)
+	})
+
+	if !queued {
+		forgetRequest(ctx, req)
+		queueFull(ctx, w)
+
+		return
+	}
+
+	var result *webhook.Result
+
+	select {
+	case result = <-resultCh:
+	case <-time.After(timeout):
+		result = &webhook.Result{
+			ID:      req.ID,
+			Status:  http.StatusAccepted,
+			Message: fmt.Sprintf("request still in progress after %s, queued request id %s", timeout, req.ID),
 		}
+	}
 
-		// Process the request
-		gitHub.HandleRequest()
-	}()
+	out, err := json.Marshal(result)
+
+	if err != nil {
+		utils.LogContext(ctx, "ERROR", err.Error())
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "some internal error ocurred")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(result.Status)
+	w.Write(out)
 }
 
-func getGithubEvent(r *http.Request) (string, error) {
-	// Get X-GitHub-Event header
-	event := r.Header.Get("X-GitHub-Event")
+// newProviders returns all providers. Each one has its own endpoint /api/v1/<provider name>
+func newProviders(checkGithubIps bool) []webhook.Provider {
+	githubApiUrl := os.Getenv("GITHUB_API_URL")
 
-	if len(event) == 0 {
-		return "", errors.New("X-GitHub-Event header not found")
+	if len(githubApiUrl) == 0 {
+		githubApiUrl = commitstatus.DefaultGitHubApiUrl
 	}
 
-	return event, nil
+	return []webhook.Provider{
+		&githubv1.GitHub{CheckIps: checkGithubIps, ApiUrl: githubApiUrl, Token: os.Getenv("GITHUB_TOKEN")},
+		&gitlabv1.GitLab{},
+		&bitbucketv1.Bitbucket{},
+		&giteav1.Gitea{},
+		&cloudeventsv1.CloudEvents{},
+	}
 }
 
 func main() {
+	err := utils.InitLog(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "render":
+			os.Exit(runRender(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown subcommand %s\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
+
 	podNamespace := os.Getenv("POD_NAMESPACE")
 	port := os.Getenv("LISTEN_PORT")
+	portMetrics := os.Getenv("METRICS_PORT")
 	checkGithubIpsEnv := os.Getenv("CHECK_GITHUB_IPS")
 	pipelinesNamespace := os.Getenv("PIPELINES_NAMESPACE")
 
@@ -164,6 +383,10 @@ func main() {
 		port = listenPort
 	}
 
+	if len(portMetrics) == 0 {
+		portMetrics = metricsPort
+	}
+
 	if len(checkGithubIpsEnv) == 0 {
 		// Default true
 		checkGithubIps = true
@@ -182,8 +405,21 @@ func main() {
 		os.Setenv("PIPELINES_NAMESPACE", podNamespace)
 	}
 
+	err = k8s.Init()
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	// Tracing (no-op unless OTEL_TRACES_EXPORTER is set)
+	shutdownTracing, err := tracing.Init(context.Background())
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
 	// Load configuration
-	err := config.LoadConfig(checkGithubIps)
+	err = config.LoadConfig(checkGithubIps)
 
 	if err != nil {
 		utils.Log("FATAL", fmt.Sprintf("unable to load app configuration: %s", err.Error()))
@@ -196,28 +432,296 @@ func main() {
 		utils.Log("FATAL", err.Error())
 	}
 
+	// Reload configuration when changes
+	stopWatchCh := make(chan struct{})
+
+	go func() {
+		err := config.WatchConfig(stopWatchCh, func(kind string, name string, err error) {
+			metrics.ConfigReloaded(err)
+
+			if err != nil {
+				utils.Log("ERROR", fmt.Sprintf("new configuration rejected, keeping the active one: %s", err.Error()))
+				return
+			}
+
+			utils.Log("INFO", fmt.Sprintf("configuration reloaded after changing %s %s", kind, name))
+		})
+
+		if err != nil {
+			utils.Log("ERROR", fmt.Sprintf("unable to watch configuration: %s", err.Error()))
+		}
+	}()
+
+	// How long the deliveries are remembered in memory to skip the redeliveries
+	deliveryTTL, err := getDurationEnv("DELIVERY_CACHE_TTL", 0)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	if deliveryTTL > 0 {
+		webhook.SetDeliveryTTL(deliveryTTL)
+	}
+
+	// Start the pending PipelineRuns of the pipelines with maxConcurrent
+	promoteInterval, err := getDurationEnv("PROMOTE_INTERVAL", defaultPromoteInterval)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	go tekton.RunPromoter(stopWatchCh, promoteInterval)
+
+	// Subscribers notified when the state of the PipelineRuns changes
+	pipelineRunWatcher := watcher.New()
+
+	// Report the state of the PipelineRuns as GitHub commit statuses
+	githubToken := os.Getenv("GITHUB_TOKEN")
+
+	if len(githubToken) > 0 {
+		githubStatus, err := commitstatus.NewGitHub(os.Getenv("GITHUB_API_URL"), githubToken, os.Getenv("GITHUB_STATUS_CONTEXT"),
+			os.Getenv("DASHBOARD_URL"))
+
+		if err != nil {
+			utils.Log("FATAL", err.Error())
+		}
+
+		pipelineRunWatcher.Subscribe(githubStatus)
+	}
+
+	// Send the notifications configured in the pipelines when their PipelineRuns finish
+	//
+	// Note: It is always subscribed because the notifications can be added when the configuration
+	// is reloaded
+	pipelineRunWatcher.Subscribe(notify.New())
+
+	go func() {
+		err := pipelineRunWatcher.Run(stopWatchCh)
+
+		if err != nil {
+			utils.Log("ERROR", fmt.Sprintf("unable to watch pipelineruns: %s", err.Error()))
+		}
+	}()
+
+	// Retries of the creation of the PipelineRuns
+	maxAttempts, err := getIntEnv("CREATE_MAX_ATTEMPTS", tekton.DefaultMaxAttempts)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	initialBackoff, err := getDurationEnv("CREATE_INITIAL_BACKOFF", tekton.DefaultInitialBackoff)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	maxBackoff, err := getDurationEnv("CREATE_MAX_BACKOFF", tekton.DefaultMaxBackoff)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	tekton.SetRetryPolicy(tekton.RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+	})
+
+	// Workers that process the requests in background
+	numWorkers, err := getIntEnv("WORKERS", queue.DefaultWorkers)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	queueSize, err := getIntEnv("QUEUE_SIZE", queue.DefaultQueueSize)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	workers = queue.NewPool(numWorkers, queueSize)
+
+	providers := newProviders(checkGithubIps)
+
+	// Persist the accepted requests to replay them after a restart
+	queueDir := os.Getenv("QUEUE_DIR")
+
+	if len(queueDir) > 0 {
+		store, err = queue.NewStore(queueDir)
+
+		if err != nil {
+			utils.Log("FATAL", fmt.Sprintf("unable to open queue dir: %s", err.Error()))
+		}
+
+		// Note: The requests are listed before the server starts, the requests persisted from now
+		// on are processed by their handlers
+		records, err := store.List()
+
+		if err != nil {
+			utils.Log("ERROR", fmt.Sprintf("unable to read queue dir: %s", err.Error()))
+		}
+
+		go replayRequests(records, providers)
+	}
+
+	// Requests that failed permanently, by default in a subdirectory of QUEUE_DIR
+	deadLetterDir := os.Getenv("DEAD_LETTER_DIR")
+
+	if len(deadLetterDir) == 0 && len(queueDir) > 0 {
+		deadLetterDir = filepath.Join(queueDir, "dead-letter")
+	}
+
+	if len(deadLetterDir) > 0 {
+		deadLetters, err = queue.NewStore(deadLetterDir)
+
+		if err != nil {
+			utils.Log("FATAL", fmt.Sprintf("unable to open dead letter dir: %s", err.Error()))
+		}
+	}
+
+	maxDeadLetters, err = getIntEnv("DEAD_LETTER_MAX", defaultMaxDeadLetters)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
 	r := mux.NewRouter()
 
-	r.HandleFunc("/api/v1/github", gitHubListenerV1).Methods("POST") // Only POST allowed
+	for _, provider := range providers {
+		r.HandleFunc(fmt.Sprintf("/api/v1/%s", provider.Name()), webhookListenerV1(provider)).Methods("POST") // Only POST allowed
+	}
+
+	// API to list and replay the dead letters
+	adminToken := os.Getenv("ADMIN_TOKEN")
+
+	if len(adminToken) > 0 {
+		if deadLetters == nil {
+			utils.Log("FATAL", "ADMIN_TOKEN requires DEAD_LETTER_DIR or QUEUE_DIR")
+		}
+
+		registerAdminApi(r, adminToken, providers)
+	}
+
 	r.HandleFunc("/startup", startupHealthCheck)
 	r.HandleFunc("/liveness", healthCheck)
-	r.HandleFunc("/readiness", healthCheck)
+	r.HandleFunc("/readiness", readinessHealthCheck)
 	r.HandleFunc("/", healthCheck)
 
 	srv := &http.Server{
 		Handler:      r,
-		Addr:         fmt.Sprintf(":%s", listenPort),
+		Addr:         fmt.Sprintf(":%s", port),
+		WriteTimeout: config.WriteTimeout,
+		ReadTimeout:  15 * time.Second,
+	}
+
+	// TLS termination
+	//
+	// Note: The certificate is read from files (TLS_CERT_FILE and TLS_KEY_FILE) or from a
+	// Kubernetes TLS Secret (TLS_SECRET_NAME)
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	tlsSecretName := os.Getenv("TLS_SECRET_NAME")
+
+	if len(tlsCertFile) > 0 || len(tlsKeyFile) > 0 || len(tlsSecretName) > 0 {
+		reloadInterval, err := getDurationEnv("TLS_RELOAD_INTERVAL", 0)
+
+		if err != nil {
+			utils.Log("FATAL", err.Error())
+		}
+
+		certReloader, err := certs.NewReloader(certs.Options{
+			CertFile:       tlsCertFile,
+			KeyFile:        tlsKeyFile,
+			SecretName:     tlsSecretName,
+			Namespace:      podNamespace,
+			ClientCAFile:   os.Getenv("TLS_CLIENT_CA_FILE"),
+			ClientAuth:     os.Getenv("TLS_CLIENT_AUTH"),
+			ReloadInterval: reloadInterval,
+		})
+
+		if err != nil {
+			utils.Log("FATAL", fmt.Sprintf("unable to configure tls: %s", err.Error()))
+		}
+
+		go certReloader.Run(stopWatchCh)
+
+		srv.TLSConfig = certReloader.TLSConfig()
+	}
+
+	// Metrics are exposed in a separate port to not publish them along with the webhooks
+	metricsRouter := mux.NewRouter()
+	metricsRouter.Handle("/metrics", metrics.Handler())
+
+	metricsSrv := &http.Server{
+		Handler:      metricsRouter,
+		Addr:         fmt.Sprintf(":%s", portMetrics),
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
 
-	utils.Log("INFO", fmt.Sprintf("server listening on port %s", listenPort))
+	go func() {
+		utils.Log("INFO", fmt.Sprintf("metrics listening on port %s", portMetrics))
 
-	isServerReady = true
+		err := metricsSrv.ListenAndServe()
 
-	err = srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			utils.Log("FATAL", fmt.Sprintf("unable to serve metrics: %s", err.Error()))
+		}
+	}()
+
+	// Graceful shutdown
+	shutdownDelay, err := getDurationEnv("SHUTDOWN_DELAY", defaultShutdownDelay)
 
 	if err != nil {
 		utils.Log("FATAL", err.Error())
 	}
+
+	drainTimeout, err := getDurationEnv("DRAIN_TIMEOUT", defaultDrainTimeout)
+
+	if err != nil {
+		utils.Log("FATAL", err.Error())
+	}
+
+	shutdownDone := make(chan struct{})
+
+	go handleShutdown(srv, shutdownDelay, drainTimeout, shutdownDone)
+
+	isServerReady = true
+
+	if srv.TLSConfig != nil {
+		utils.Log("INFO", fmt.Sprintf("server listening on port %s (https)", port))
+
+		// Note: The certificate is provided by TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		utils.Log("INFO", fmt.Sprintf("server listening on port %s", port))
+
+		err = srv.ListenAndServe()
+	}
+
+	// Note: ErrServerClosed is returned as soon as the shutdown starts
+	if err != nil && err != http.ErrServerClosed {
+		utils.Log("FATAL", err.Error())
+	}
+
+	<-shutdownDone
+
+	// Stop watching the configuration, the certificate and the pending PipelineRuns
+	close(stopWatchCh)
+
+	metricsSrv.Close()
+
+	// Flush the pending spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = shutdownTracing(ctx)
+
+	if err != nil {
+		utils.Log("ERROR", fmt.Sprintf("unable to flush traces: %s", err.Error()))
+	}
+
+	utils.Log("INFO", "server stopped")
 }
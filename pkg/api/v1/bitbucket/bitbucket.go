@@ -0,0 +1,91 @@
+package bitbucket
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+// Bitbucket implements webhook.Provider for Bitbucket Server and Bitbucket Cloud
+type Bitbucket struct{}
+
+func (b *Bitbucket) Name() string {
+	return "bitbucket"
+}
+
+func (b *Bitbucket) Event(r *http.Request) (string, error) {
+	// Get X-Event-Key header
+	event := r.Header.Get("X-Event-Key")
+
+	if len(event) == 0 {
+		return "", errors.New("X-Event-Key header not found")
+	}
+
+	return event, nil
+}
+
+// DeliveryId returns the header X-Request-UUID (Bitbucket Cloud) or X-Request-Id (Bitbucket
+// Server and Data Center)
+func (b *Bitbucket) DeliveryId(r *http.Request) string {
+	id := r.Header.Get("X-Request-UUID")
+
+	if len(id) == 0 {
+		id = r.Header.Get("X-Request-Id")
+	}
+
+	return id
+}
+
+// IsPing checks the event Bitbucket Server sends with the "Test connection" button
+func (b *Bitbucket) IsPing(event string) bool {
+	return event == "diagnostics:ping"
+}
+
+func (b *Bitbucket) CheckSourceIp(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+func (b *Bitbucket) VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error) {
+	// Get password por this type of pipeline
+	bitbucketPass := pipeline.BitbucketPassword
+
+	if len(bitbucketPass) == 0 {
+		// Password for this particular pipeline not found, try global password
+		bitbucketPass = config.GetGlobalBitbucketPassword()
+	}
+
+	if len(bitbucketPass) == 0 {
+		// Not a secure webhook
+		return true, nil
+	}
+
+	return isValidSignature(r, payload, bitbucketPass)
+}
+
+// Normalize adds the GitHub fields to the Bitbucket payload (see normalizePayload)
+func (b *Bitbucket) Normalize(r *http.Request, event string, payload []byte) (*webhook.Event, error) {
+	return normalizePayload(event, payload)
+}
+
+// isValidSignature checks the X-Hub-Signature header. Bitbucket signs the payload with
+// HMAC-SHA256 and the format sha256=hexdigest
+func isValidSignature(r *http.Request, payload []byte, secret string) (bool, error) {
+	// Get X-Hub-Signature header
+	signatureHeader := r.Header.Get("X-Hub-Signature")
+
+	if len(signatureHeader) == 0 {
+		return false, errors.New("X-Hub-Signature header not found")
+	}
+
+	gotHash := strings.SplitN(signatureHeader, "=", 2)
+
+	if len(gotHash) != 2 || gotHash[0] != "sha256" {
+		return false, errors.New("sha256 not found in signature header")
+	}
+
+	return webhook.IsValidHmac(gotHash[1], sha256.New, secret, payload)
+}
@@ -0,0 +1,210 @@
+package bitbucket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+// Bitbucket Server and Bitbucket Cloud send push and pull request payloads with their
+// own format. normalizePayload adds the fields GitHub sends (ref, before, after,
+// repository.full_name, repository.clone_url and pull_request) to the payload, so the
+// when clauses and pipelines written for GitHub work with Bitbucket as well.
+//
+// Note: The original fields are kept and the GitHub fields are only added if they
+//       do not exist in the payload
+
+type pullRequest struct {
+	Number  int64
+	HeadRef string
+	HeadSha string
+	BaseRef string
+}
+
+type normalizedPayload struct {
+	Ref          string
+	Before       string
+	After        string
+	RepoFullName string
+	RepoCloneUrl string
+	PullRequest  *pullRequest
+}
+
+func normalizePayload(event string, payload []byte) (*webhook.Event, error) {
+	var n *normalizedPayload
+
+	data := string(payload)
+
+	switch {
+	case event == "repo:refs_changed":
+		// Bitbucket Server push
+		n = &normalizedPayload{
+			Ref:    gjson.Get(data, "changes.0.ref.id").String(),
+			Before: gjson.Get(data, "changes.0.fromHash").String(),
+			After:  gjson.Get(data, "changes.0.toHash").String(),
+		}
+
+		n.RepoFullName, n.RepoCloneUrl = serverRepository(gjson.Get(data, "repository"))
+	case event == "repo:push":
+		// Bitbucket Cloud push
+		n = &normalizedPayload{
+			Ref:    cloudRef(gjson.Get(data, "push.changes.0.new")),
+			Before: gjson.Get(data, "push.changes.0.old.target.hash").String(),
+			After:  gjson.Get(data, "push.changes.0.new.target.hash").String(),
+		}
+
+		n.RepoFullName, n.RepoCloneUrl = cloudRepository(gjson.Get(data, "repository"))
+	case strings.HasPrefix(event, "pr:"):
+		// Bitbucket Server pull request
+		pr := gjson.Get(data, "pullRequest")
+
+		n = &normalizedPayload{
+			Ref:   pr.Get("fromRef.id").String(),
+			After: pr.Get("fromRef.latestCommit").String(),
+			PullRequest: &pullRequest{
+				Number:  pr.Get("id").Int(),
+				HeadRef: pr.Get("fromRef.id").String(),
+				HeadSha: pr.Get("fromRef.latestCommit").String(),
+				BaseRef: pr.Get("toRef.id").String(),
+			},
+		}
+
+		n.RepoFullName, n.RepoCloneUrl = serverRepository(pr.Get("toRef.repository"))
+	case strings.HasPrefix(event, "pullrequest:"):
+		// Bitbucket Cloud pull request
+		pr := gjson.Get(data, "pullrequest")
+
+		n = &normalizedPayload{
+			Ref:   "refs/heads/" + pr.Get("source.branch.name").String(),
+			After: pr.Get("source.commit.hash").String(),
+			PullRequest: &pullRequest{
+				Number:  pr.Get("id").Int(),
+				HeadRef: "refs/heads/" + pr.Get("source.branch.name").String(),
+				HeadSha: pr.Get("source.commit.hash").String(),
+				BaseRef: "refs/heads/" + pr.Get("destination.branch.name").String(),
+			},
+		}
+
+		n.RepoFullName, n.RepoCloneUrl = cloudRepository(gjson.Get(data, "repository"))
+	default:
+		// Nothing to normalize
+		return &webhook.Event{Type: event, Payload: payload}, nil
+	}
+
+	var target map[string]interface{}
+
+	// Keep numbers as they are
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	err := dec.Decode(&target)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot normalize payload: %s", err.Error())
+	}
+
+	setIfNotExists(target, "ref", n.Ref)
+	setIfNotExists(target, "before", n.Before)
+	setIfNotExists(target, "after", n.After)
+
+	repository, ok := target["repository"].(map[string]interface{})
+
+	if !ok {
+		repository = make(map[string]interface{})
+		target["repository"] = repository
+	}
+
+	setIfNotExists(repository, "full_name", n.RepoFullName)
+	setIfNotExists(repository, "clone_url", n.RepoCloneUrl)
+
+	if n.PullRequest != nil {
+		if _, ok := target["pull_request"]; !ok {
+			target["pull_request"] = map[string]interface{}{
+				"number": n.PullRequest.Number,
+				"head": map[string]interface{}{
+					"ref": n.PullRequest.HeadRef,
+					"sha": n.PullRequest.HeadSha,
+				},
+				"base": map[string]interface{}{
+					"ref": n.PullRequest.BaseRef,
+				},
+			}
+		}
+	}
+
+	normalized, err := json.Marshal(target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &webhook.Event{
+		Type:    event,
+		Payload: normalized,
+		Repo:    n.RepoFullName,
+		RepoUrl: n.RepoCloneUrl,
+		Ref:     n.Ref,
+		Sha:     n.After,
+	}
+
+	return e, nil
+}
+
+// serverRepository returns the full name and the http clone url from a Bitbucket Server repository
+func serverRepository(repo gjson.Result) (string, string) {
+	var cloneUrl string
+
+	fullName := fmt.Sprintf("%s/%s", repo.Get("project.key").String(), repo.Get("slug").String())
+
+	for _, link := range repo.Get("links.clone").Array() {
+		if link.Get("name").String() == "http" {
+			cloneUrl = link.Get("href").String()
+			break
+		}
+	}
+
+	return fullName, cloneUrl
+}
+
+// cloudRepository returns the full name and the http clone url from a Bitbucket Cloud repository
+func cloudRepository(repo gjson.Result) (string, string) {
+	fullName := repo.Get("full_name").String()
+	cloneUrl := repo.Get("links.html.href").String()
+
+	if len(cloneUrl) > 0 {
+		cloneUrl += ".git"
+	}
+
+	return fullName, cloneUrl
+}
+
+// cloudRef builds the git reference from a Bitbucket Cloud push change
+func cloudRef(change gjson.Result) string {
+	name := change.Get("name").String()
+
+	if len(name) == 0 {
+		// Branch or tag deleted
+		return ""
+	}
+
+	if change.Get("type").String() == "tag" {
+		return "refs/tags/" + name
+	}
+
+	return "refs/heads/" + name
+}
+
+func setIfNotExists(m map[string]interface{}, key string, value string) {
+	if len(value) == 0 {
+		return
+	}
+
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
@@ -0,0 +1,185 @@
+package bitbucket
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		payload string
+		repo    string
+		repoUrl string
+		ref     string
+		sha     string
+
+		// Fields of the normalized payload (gjson path -> value)
+		fields map[string]string
+	}{
+		{
+			name:  "server push",
+			event: "repo:refs_changed",
+			payload: `{
+				"repository": {
+					"slug": "repo",
+					"project": {"key": "PRJ"},
+					"links": {"clone": [
+						{"name": "ssh", "href": "ssh://git@bitbucket.example.com:7999/prj/repo.git"},
+						{"name": "http", "href": "https://bitbucket.example.com/scm/prj/repo.git"}
+					]}
+				},
+				"changes": [{
+					"ref": {"id": "refs/heads/main", "type": "BRANCH"},
+					"fromHash": "1111111111111111111111111111111111111111",
+					"toHash": "2222222222222222222222222222222222222222"
+				}]
+			}`,
+			repo:    "PRJ/repo",
+			repoUrl: "https://bitbucket.example.com/scm/prj/repo.git",
+			ref:     "refs/heads/main",
+			sha:     "2222222222222222222222222222222222222222",
+			fields: map[string]string{
+				"ref":                  "refs/heads/main",
+				"before":               "1111111111111111111111111111111111111111",
+				"after":                "2222222222222222222222222222222222222222",
+				"repository.full_name": "PRJ/repo",
+				"repository.clone_url": "https://bitbucket.example.com/scm/prj/repo.git",
+			},
+		},
+		{
+			name:  "cloud push of a tag",
+			event: "repo:push",
+			payload: `{
+				"repository": {
+					"full_name": "workspace/repo",
+					"links": {"html": {"href": "https://bitbucket.org/workspace/repo"}}
+				},
+				"push": {"changes": [{
+					"old": null,
+					"new": {"type": "tag", "name": "v1.0.0", "target": {"hash": "3333333333333333333333333333333333333333"}}
+				}]}
+			}`,
+			repo:    "workspace/repo",
+			repoUrl: "https://bitbucket.org/workspace/repo.git",
+			ref:     "refs/tags/v1.0.0",
+			sha:     "3333333333333333333333333333333333333333",
+			fields: map[string]string{
+				"ref":                  "refs/tags/v1.0.0",
+				"after":                "3333333333333333333333333333333333333333",
+				"repository.full_name": "workspace/repo",
+				"repository.clone_url": "https://bitbucket.org/workspace/repo.git",
+			},
+		},
+		{
+			name:  "server pull request",
+			event: "pr:opened",
+			payload: `{
+				"pullRequest": {
+					"id": 12,
+					"fromRef": {"id": "refs/heads/feature", "latestCommit": "4444444444444444444444444444444444444444"},
+					"toRef": {
+						"id": "refs/heads/main",
+						"repository": {"slug": "repo", "project": {"key": "PRJ"}}
+					}
+				}
+			}`,
+			repo: "PRJ/repo",
+			ref:  "refs/heads/feature",
+			sha:  "4444444444444444444444444444444444444444",
+			fields: map[string]string{
+				"pull_request.number":   "12",
+				"pull_request.head.ref": "refs/heads/feature",
+				"pull_request.head.sha": "4444444444444444444444444444444444444444",
+				"pull_request.base.ref": "refs/heads/main",
+			},
+		},
+		{
+			name:  "cloud pull request",
+			event: "pullrequest:created",
+			payload: `{
+				"repository": {
+					"full_name": "workspace/repo",
+					"links": {"html": {"href": "https://bitbucket.org/workspace/repo"}}
+				},
+				"pullrequest": {
+					"id": 5,
+					"source": {"branch": {"name": "feature"}, "commit": {"hash": "555555555555"}},
+					"destination": {"branch": {"name": "main"}}
+				}
+			}`,
+			repo:    "workspace/repo",
+			repoUrl: "https://bitbucket.org/workspace/repo.git",
+			ref:     "refs/heads/feature",
+			sha:     "555555555555",
+			fields: map[string]string{
+				"pull_request.number":   "5",
+				"pull_request.head.ref": "refs/heads/feature",
+				"pull_request.head.sha": "555555555555",
+				"pull_request.base.ref": "refs/heads/main",
+			},
+		},
+		{
+			name:  "fields of the payload are kept",
+			event: "repo:push",
+			payload: `{
+				"ref": "keep",
+				"repository": {"full_name": "workspace/repo"},
+				"push": {"changes": [{"new": {"type": "branch", "name": "main", "target": {"hash": "666666666666"}}}]}
+			}`,
+			repo: "workspace/repo",
+			ref:  "refs/heads/main",
+			sha:  "666666666666",
+			fields: map[string]string{
+				"ref":   "keep",
+				"after": "666666666666",
+			},
+		},
+		{
+			name:    "other events",
+			event:   "repo:comment:created",
+			payload: `{"comment": {"id": 1}}`,
+			fields: map[string]string{
+				"comment.id": "1",
+			},
+		},
+	}
+
+	b := &Bitbucket{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/bitbucket", nil)
+
+			e, err := b.Normalize(r, tt.event, []byte(tt.payload))
+
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+
+			if e.Type != tt.event || e.Repo != tt.repo || e.RepoUrl != tt.repoUrl || e.Ref != tt.ref || e.Sha != tt.sha {
+				t.Errorf("Normalize() = {%s %s %s %s %s}, want {%s %s %s %s %s}", e.Type, e.Repo, e.RepoUrl, e.Ref, e.Sha,
+					tt.event, tt.repo, tt.repoUrl, tt.ref, tt.sha)
+			}
+
+			for path, want := range tt.fields {
+				if got := gjson.GetBytes(e.Payload, path).String(); got != want {
+					t.Errorf("payload %s = %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeMalformed(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/v1/bitbucket", nil)
+
+	_, err := (&Bitbucket{}).Normalize(r, "repo:push", []byte(`{"push":`))
+
+	if err == nil {
+		t.Errorf("Normalize() of a malformed payload did not fail")
+	}
+}
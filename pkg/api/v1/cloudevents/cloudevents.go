@@ -0,0 +1,273 @@
+package cloudevents
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+const (
+	// Content type of structured mode
+	structuredContentType string = "application/cloudevents+json"
+
+	// Content type of batched mode (not supported)
+	batchContentType string = "application/cloudevents-batch+json"
+
+	// Prefix of the http headers in binary mode
+	headerPrefix string = "ce-"
+)
+
+// Attributes provided as PipelineRun params (attribute name -> param name)
+var attributesParams map[string]string = map[string]string{
+	"id":      "ceId",
+	"type":    "ceType",
+	"source":  "ceSource",
+	"subject": "ceSubject",
+}
+
+// CloudEvents implements webhook.Provider for CloudEvents over HTTP, in binary and
+// structured content modes
+type CloudEvents struct{}
+
+// structuredEvent is an event in structured mode. Data is kept raw because it can be
+// any json value
+type structuredEvent struct {
+	Id              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	Subject         string          `json:"subject"`
+	SpecVersion     string          `json:"specversion"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	DataBase64      string          `json:"data_base64"`
+}
+
+func (c *CloudEvents) Name() string {
+	return "cloudevents"
+}
+
+// Event returns the attribute type. In structured mode the body is parsed to get it
+func (c *CloudEvents) Event(r *http.Request) (string, error) {
+	if isStructured(r) {
+		e, err := parseStructured(r)
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(e.Type) == 0 {
+			return "", errors.New("type attribute not found in cloudevent")
+		}
+
+		return e.Type, nil
+	}
+
+	if isBatch(r) {
+		return "", errors.New("batched cloudevents are not supported")
+	}
+
+	// Binary mode
+	event := r.Header.Get("ce-type")
+
+	if len(event) == 0 {
+		return "", errors.New("ce-type header not found")
+	}
+
+	return event, nil
+}
+
+// DeliveryId returns the attributes source and id, which identify the event. The senders must
+// keep the same id when they retry the event
+func (c *CloudEvents) DeliveryId(r *http.Request) string {
+	var source, id string
+
+	if isStructured(r) {
+		e, err := parseStructured(r)
+
+		if err != nil {
+			return ""
+		}
+
+		source, id = e.Source, e.Id
+	} else {
+		source = r.Header.Get("ce-source")
+		id = r.Header.Get("ce-id")
+	}
+
+	if len(id) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s#%s", source, id)
+}
+
+// IsPing returns always false, CloudEvents does not have a ping event
+func (c *CloudEvents) IsPing(event string) bool {
+	return false
+}
+
+func (c *CloudEvents) CheckSourceIp(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+// VerifySignature checks the token in the Authorization header (Bearer). CloudEvents does not
+// define how to sign the events
+func (c *CloudEvents) VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error) {
+	// Get token for this type of pipeline
+	token := pipeline.CloudEventsToken
+
+	if len(token) == 0 {
+		// Token for this particular pipeline not found, try global token
+		token = config.GetGlobalCloudEventsToken()
+	}
+
+	if len(token) == 0 {
+		// Not a secure endpoint
+		return true, nil
+	}
+
+	authHeader := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return false, errors.New("bearer token not found in Authorization header")
+	}
+
+	// Constant time comparison
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authHeader, "Bearer ")), []byte(token)) == 1, nil
+}
+
+// Normalize gets the attributes of the event. The payload provided to the PipelineRun is
+// the data of the event
+func (c *CloudEvents) Normalize(r *http.Request, event string, payload []byte) (*webhook.Event, error) {
+	if isStructured(r) {
+		return normalizeStructured(event, payload)
+	}
+
+	// Binary mode, the attributes are the http headers ce-*
+	attributes := make(map[string]string)
+
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+
+		if strings.HasPrefix(name, headerPrefix) && len(values) > 0 {
+			attributes[strings.TrimPrefix(name, headerPrefix)] = values[0]
+		}
+	}
+
+	// In binary mode datacontenttype is the Content-Type header
+	attributes["datacontenttype"] = r.Header.Get("Content-Type")
+
+	return newEvent(event, payload, attributes), nil
+}
+
+func normalizeStructured(event string, payload []byte) (*webhook.Event, error) {
+	var e structuredEvent
+
+	err := json.Unmarshal(payload, &e)
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed cloudevent: %s", err.Error())
+	}
+
+	data := []byte(e.Data)
+
+	if len(e.DataBase64) > 0 {
+		data, err = base64.StdEncoding.DecodeString(e.DataBase64)
+
+		if err != nil {
+			return nil, fmt.Errorf("malformed data_base64 in cloudevent: %s", err.Error())
+		}
+	}
+
+	attributes := map[string]string{
+		"id":              e.Id,
+		"type":            e.Type,
+		"source":          e.Source,
+		"subject":         e.Subject,
+		"specversion":     e.SpecVersion,
+		"time":            e.Time,
+		"datacontenttype": e.DataContentType,
+	}
+
+	return newEvent(event, data, attributes), nil
+}
+
+func newEvent(event string, data []byte, attributes map[string]string) *webhook.Event {
+	params := make(map[string]string)
+
+	for attr, param := range attributesParams {
+		params[param] = attributes[attr]
+	}
+
+	return &webhook.Event{
+		Type:       event,
+		Payload:    data,
+		Attributes: attributes,
+		Params:     params,
+	}
+}
+
+// structuredBody is the body of a request in structured mode along with the event parsed, so it
+// is parsed once and the body can be read again
+type structuredBody struct {
+	io.Reader
+	event *structuredEvent
+	err   error
+}
+
+func (b *structuredBody) Close() error {
+	return nil
+}
+
+// parseStructured returns the event of a request in structured mode. The body is read and parsed
+// the first time, and replaced by a structuredBody
+func parseStructured(r *http.Request) (*structuredEvent, error) {
+	if b, ok := r.Body.(*structuredBody); ok {
+		return b.event, b.err
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read payload: %s", err.Error())
+	}
+
+	b := &structuredBody{
+		Reader: bytes.NewReader(body),
+		event:  &structuredEvent{},
+	}
+
+	err = json.Unmarshal(body, b.event)
+
+	if err != nil {
+		b.event, b.err = nil, fmt.Errorf("malformed cloudevent: %s", err.Error())
+	}
+
+	r.Body = b
+
+	return b.event, b.err
+}
+
+func isStructured(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	return mediaType == structuredContentType
+}
+
+func isBatch(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	return mediaType == batchContentType
+}
@@ -0,0 +1,370 @@
+package cloudevents
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+)
+
+const structuredPayload string = `{
+	"specversion": "1.0",
+	"id": "A234-1234-1234",
+	"type": "com.example.build.requested",
+	"source": "/builds/app",
+	"subject": "main",
+	"datacontenttype": "application/json",
+	"data": {"ref": "refs/heads/main"}
+}`
+
+func newRequest(contentType string, headers map[string]string, payload string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/v1/cloudevents?pipeline=build&prefix=build", strings.NewReader(payload))
+
+	r.Header.Set("Content-Type", contentType)
+
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+
+	return r
+}
+
+func binaryHeaders() map[string]string {
+	return map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          "A234-1234-1234",
+		"ce-type":        "com.example.build.requested",
+		"ce-source":      "/builds/app",
+		"ce-subject":     "main",
+	}
+}
+
+func TestEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		payload     string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "binary",
+			contentType: "application/json",
+			headers:     binaryHeaders(),
+			payload:     `{"ref": "refs/heads/main"}`,
+			want:        "com.example.build.requested",
+		},
+		{
+			name:        "binary without type",
+			contentType: "application/json",
+			payload:     `{"ref": "refs/heads/main"}`,
+			wantErr:     true,
+		},
+		{
+			name:        "structured",
+			contentType: "application/cloudevents+json; charset=utf-8",
+			payload:     structuredPayload,
+			want:        "com.example.build.requested",
+		},
+		{
+			name:        "structured without type",
+			contentType: "application/cloudevents+json",
+			payload:     `{"specversion": "1.0", "id": "1", "source": "/builds/app"}`,
+			wantErr:     true,
+		},
+		{
+			name:        "structured malformed",
+			contentType: "application/cloudevents+json",
+			payload:     `{"type":`,
+			wantErr:     true,
+		},
+		{
+			name:        "batch",
+			contentType: "application/cloudevents-batch+json",
+			payload:     "[" + structuredPayload + "]",
+			wantErr:     true,
+		},
+	}
+
+	c := &CloudEvents{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(tt.contentType, tt.headers, tt.payload)
+
+			got, err := c.Event(r)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Event() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Event() = %q, want %q", got, tt.want)
+			}
+
+			// The payload is read after getting the event
+			body, err := ioutil.ReadAll(r.Body)
+
+			if err != nil || string(body) != tt.payload {
+				t.Errorf("body after Event() = %q, %v, want the payload", body, err)
+			}
+		})
+	}
+}
+
+func TestParseStructuredOnce(t *testing.T) {
+	c := &CloudEvents{}
+
+	r := newRequest("application/cloudevents+json", nil, structuredPayload)
+
+	event, err := c.Event(r)
+
+	if err != nil || event != "com.example.build.requested" {
+		t.Fatalf("Event() = %q, %v", event, err)
+	}
+
+	body, ok := r.Body.(*structuredBody)
+
+	if !ok {
+		t.Fatalf("body %T, want the structured body parsed", r.Body)
+	}
+
+	// The delivery id is taken from the event parsed by Event
+	if got, want := c.DeliveryId(r), "/builds/app#A234-1234-1234"; got != want {
+		t.Errorf("DeliveryId() = %q, want %q", got, want)
+	}
+
+	if r.Body != body {
+		t.Error("DeliveryId() parsed the body again")
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+
+	if err != nil || string(payload) != structuredPayload {
+		t.Errorf("body = %q, %v, want the payload", payload, err)
+	}
+
+	// A malformed event is parsed once as well
+	r = newRequest("application/cloudevents+json", nil, "{")
+
+	if _, err := c.Event(r); err == nil {
+		t.Error("Event() of a malformed event did not fail")
+	}
+
+	if got := c.DeliveryId(r); len(got) > 0 {
+		t.Errorf("DeliveryId() of a malformed event = %q", got)
+	}
+
+	if payload, _ := ioutil.ReadAll(r.Body); string(payload) != "{" {
+		t.Errorf("body = %q, want the payload", payload)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	params := map[string]string{
+		"ceId":      "A234-1234-1234",
+		"ceType":    "com.example.build.requested",
+		"ceSource":  "/builds/app",
+		"ceSubject": "main",
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		payload     string
+		data        string
+		attributes  map[string]string // Attributes checked, not all of them
+		params      map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "binary",
+			contentType: "application/json",
+			headers:     binaryHeaders(),
+			payload:     `{"ref": "refs/heads/main"}`,
+			data:        `{"ref": "refs/heads/main"}`,
+			attributes: map[string]string{
+				"type":            "com.example.build.requested",
+				"subject":         "main",
+				"datacontenttype": "application/json",
+			},
+			params: params,
+		},
+		{
+			name:        "binary without optional attributes",
+			contentType: "text/plain",
+			headers:     map[string]string{"ce-type": "com.example.ping", "ce-id": "1", "ce-source": "/ping"},
+			payload:     "ping",
+			data:        "ping",
+			attributes:  map[string]string{"datacontenttype": "text/plain"},
+			params:      map[string]string{"ceId": "1", "ceType": "com.example.ping", "ceSource": "/ping", "ceSubject": ""},
+		},
+		{
+			name:        "structured",
+			contentType: "application/cloudevents+json",
+			payload:     structuredPayload,
+			data:        `{"ref": "refs/heads/main"}`,
+			attributes: map[string]string{
+				"type":            "com.example.build.requested",
+				"specversion":     "1.0",
+				"datacontenttype": "application/json",
+			},
+			params: params,
+		},
+		{
+			name:        "structured with data_base64",
+			contentType: "application/cloudevents+json",
+			payload:     `{"specversion": "1.0", "id": "1", "type": "com.example.ping", "source": "/ping", "data_base64": "cGluZw=="}`,
+			data:        "ping",
+			params:      map[string]string{"ceId": "1", "ceType": "com.example.ping", "ceSource": "/ping", "ceSubject": ""},
+		},
+		{
+			name:        "structured with malformed data_base64",
+			contentType: "application/cloudevents+json",
+			payload:     `{"specversion": "1.0", "id": "1", "type": "com.example.ping", "data_base64": "%%%"}`,
+			wantErr:     true,
+		},
+	}
+
+	c := &CloudEvents{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(tt.contentType, tt.headers, tt.payload)
+
+			event, err := c.Event(r)
+
+			if err != nil {
+				t.Fatalf("Event() error = %v", err)
+			}
+
+			e, err := c.Normalize(r, event, []byte(tt.payload))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if e.Type != event || string(e.Payload) != tt.data {
+				t.Errorf("Normalize() = {%s %s}, want {%s %s}", e.Type, e.Payload, event, tt.data)
+			}
+
+			for name, want := range tt.attributes {
+				if got := e.Attributes[name]; got != want {
+					t.Errorf("attribute %s = %q, want %q", name, got, want)
+				}
+			}
+
+			if !reflect.DeepEqual(e.Params, tt.params) {
+				t.Errorf("params = %v, want %v", e.Params, tt.params)
+			}
+		})
+	}
+}
+
+func TestWhenCloudEvent(t *testing.T) {
+	when := func(key string, data string) []config.WhenItem {
+		return []config.WhenItem{{
+			Kind:   "cloudevent",
+			Keys:   []string{key},
+			Values: []config.ValueItem{{Operator: "equal", Data: data}},
+		}}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		payload     string
+		when        []config.WhenItem
+		want        bool
+	}{
+		{
+			name:        "binary type",
+			contentType: "application/json",
+			headers:     binaryHeaders(),
+			payload:     `{}`,
+			when:        when("type", "com.example.build.requested"),
+			want:        true,
+		},
+		{
+			name:        "binary with header prefix",
+			contentType: "application/json",
+			headers:     binaryHeaders(),
+			payload:     `{}`,
+			when:        when("ce-source", "/builds/app"),
+			want:        true,
+		},
+		{
+			name:        "binary another subject",
+			contentType: "application/json",
+			headers:     binaryHeaders(),
+			payload:     `{}`,
+			when:        when("subject", "develop"),
+		},
+		{
+			name:        "structured subject",
+			contentType: "application/cloudevents+json",
+			payload:     structuredPayload,
+			when:        when("subject", "main"),
+			want:        true,
+		},
+		{
+			name:        "structured another type",
+			contentType: "application/cloudevents+json",
+			payload:     structuredPayload,
+			when:        when("type", "com.example.build.finished"),
+		},
+		{
+			name:        "structured missing attribute",
+			contentType: "application/cloudevents+json",
+			payload:     structuredPayload,
+			when:        when("dataschema", "https://example.com/schema"),
+		},
+	}
+
+	c := &CloudEvents{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(tt.contentType, tt.headers, tt.payload)
+
+			event, err := c.Event(r)
+
+			if err != nil {
+				t.Fatalf("Event() error = %v", err)
+			}
+
+			payload, err := ioutil.ReadAll(r.Body)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := c.Normalize(r, event, payload)
+
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+
+			_, got, err := config.EvaluateWhenConditions(tt.when, r.URL.Query(), r, e.Payload, e.Attributes)
+
+			if err != nil {
+				t.Fatalf("EvaluateWhenConditions() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("EvaluateWhenConditions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
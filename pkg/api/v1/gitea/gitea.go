@@ -0,0 +1,107 @@
+package gitea
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+// Gitea implements webhook.Provider. It works for Forgejo as well
+type Gitea struct{}
+
+func (g *Gitea) Name() string {
+	return "gitea"
+}
+
+func (g *Gitea) Event(r *http.Request) (string, error) {
+	// Get X-Gitea-Event header (Forgejo sends X-Forgejo-Event as well)
+	event := r.Header.Get("X-Gitea-Event")
+
+	if len(event) == 0 {
+		event = r.Header.Get("X-Forgejo-Event")
+	}
+
+	if len(event) == 0 {
+		return "", errors.New("X-Gitea-Event header not found")
+	}
+
+	return event, nil
+}
+
+func (g *Gitea) DeliveryId(r *http.Request) string {
+	id := r.Header.Get("X-Gitea-Delivery")
+
+	if len(id) == 0 {
+		id = r.Header.Get("X-Forgejo-Delivery")
+	}
+
+	return id
+}
+
+// IsPing returns always false, Gitea does not have a ping event
+func (g *Gitea) IsPing(event string) bool {
+	return false
+}
+
+func (g *Gitea) CheckSourceIp(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+func (g *Gitea) VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error) {
+	// Get password por this type of pipeline
+	giteaPass := pipeline.GiteaPassword
+
+	if len(giteaPass) == 0 {
+		// Password for this particular pipeline not found, try global password
+		giteaPass = config.GetGlobalGiteaPassword()
+	}
+
+	if len(giteaPass) == 0 {
+		// Not a secure webhook
+		return true, nil
+	}
+
+	return isValidSignature(r, payload, giteaPass)
+}
+
+// Normalize gets the common fields. Gitea payloads have the same format as GitHub
+func (g *Gitea) Normalize(r *http.Request, event string, payload []byte) (*webhook.Event, error) {
+	data := string(payload)
+
+	e := &webhook.Event{
+		Type:    event,
+		Payload: payload,
+		Repo:    gjson.Get(data, "repository.full_name").String(),
+		RepoUrl: gjson.Get(data, "repository.clone_url").String(),
+		Ref:     gjson.Get(data, "ref").String(),
+		Sha:     gjson.Get(data, "after").String(),
+	}
+
+	if pr := gjson.Get(data, "pull_request"); pr.Exists() {
+		e.Ref = "refs/heads/" + pr.Get("head.ref").String()
+		e.Sha = pr.Get("head.sha").String()
+	}
+
+	return e, nil
+}
+
+// isValidSignature checks the X-Gitea-Signature header (X-Forgejo-Signature in Forgejo).
+// The signature is the HMAC-SHA256 of the payload in hexadecimal, without prefix
+func isValidSignature(r *http.Request, payload []byte, secret string) (bool, error) {
+	signatureHeader := r.Header.Get("X-Gitea-Signature")
+
+	if len(signatureHeader) == 0 {
+		signatureHeader = r.Header.Get("X-Forgejo-Signature")
+	}
+
+	if len(signatureHeader) == 0 {
+		return false, errors.New("X-Gitea-Signature header not found")
+	}
+
+	return webhook.IsValidHmac(signatureHeader, sha256.New, secret, payload)
+}
@@ -0,0 +1,202 @@
+package gitea
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+)
+
+const (
+	testSecret  string = "s3cret"
+	testPayload string = `{"ref":"refs/heads/main"}`
+)
+
+func sign(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	sum := sign(testSecret, testPayload)
+
+	tests := []struct {
+		name    string
+		secret  string
+		headers map[string]string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:    "no secret",
+			headers: map[string]string{},
+			want:    true,
+		},
+		{
+			name:    "valid",
+			secret:  testSecret,
+			headers: map[string]string{"X-Gitea-Signature": sum},
+			want:    true,
+		},
+		{
+			name:    "another secret",
+			secret:  testSecret,
+			headers: map[string]string{"X-Gitea-Signature": sign("other", testPayload)},
+		},
+		{
+			name:    "another payload",
+			secret:  testSecret,
+			headers: map[string]string{"X-Gitea-Signature": sign(testSecret, "{}")},
+		},
+		{
+			name:    "wrong length",
+			secret:  testSecret,
+			headers: map[string]string{"X-Gitea-Signature": sum[:32]},
+		},
+		{
+			name:    "malformed hex",
+			secret:  testSecret,
+			headers: map[string]string{"X-Gitea-Signature": "zz" + sum[2:]},
+			wantErr: true,
+		},
+		{
+			name:    "with prefix",
+			secret:  testSecret,
+			headers: map[string]string{"X-Gitea-Signature": "sha256=" + sum},
+			wantErr: true,
+		},
+		{
+			name:    "missing header",
+			secret:  testSecret,
+			headers: map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "forgejo",
+			secret:  testSecret,
+			headers: map[string]string{"X-Forgejo-Signature": sum},
+			want:    true,
+		},
+		{
+			name:    "forgejo invalid",
+			secret:  testSecret,
+			headers: map[string]string{"X-Forgejo-Signature": sign("other", testPayload)},
+		},
+		{
+			name:   "gitea preferred over forgejo",
+			secret: testSecret,
+			headers: map[string]string{
+				"X-Gitea-Signature":   sign("other", testPayload),
+				"X-Forgejo-Signature": sum,
+			},
+		},
+	}
+
+	g := &Gitea{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/gitea", strings.NewReader(testPayload))
+
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			got, err := g.VerifySignature(r, []byte(testPayload), &config.Pipeline{GiteaPassword: tt.secret})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		payload string
+		repo    string
+		repoUrl string
+		ref     string
+		sha     string
+	}{
+		{
+			name:  "push",
+			event: "push",
+			payload: `{
+				"ref": "refs/heads/main",
+				"before": "0000000000000000000000000000000000000000",
+				"after": "3e6b2e1a5f0c47d1b6f4c2d1a9e8b7c6d5e4f3a2",
+				"repository": {
+					"full_name": "owner/repo",
+					"clone_url": "https://gitea.example.com/owner/repo.git"
+				}
+			}`,
+			repo:    "owner/repo",
+			repoUrl: "https://gitea.example.com/owner/repo.git",
+			ref:     "refs/heads/main",
+			sha:     "3e6b2e1a5f0c47d1b6f4c2d1a9e8b7c6d5e4f3a2",
+		},
+		{
+			name:  "pull request",
+			event: "pull_request",
+			payload: `{
+				"action": "opened",
+				"number": 3,
+				"pull_request": {
+					"number": 3,
+					"head": {"ref": "feature", "sha": "9f8e7d6c5b4a39281706f5e4d3c2b1a098765432"},
+					"base": {"ref": "main"}
+				},
+				"repository": {
+					"full_name": "owner/repo",
+					"clone_url": "https://gitea.example.com/owner/repo.git"
+				}
+			}`,
+			repo:    "owner/repo",
+			repoUrl: "https://gitea.example.com/owner/repo.git",
+			ref:     "refs/heads/feature",
+			sha:     "9f8e7d6c5b4a39281706f5e4d3c2b1a098765432",
+		},
+		{
+			name:    "release",
+			event:   "release",
+			payload: `{"action": "published", "repository": {"full_name": "owner/repo"}}`,
+			repo:    "owner/repo",
+		},
+	}
+
+	g := &Gitea{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/gitea", nil)
+
+			e, err := g.Normalize(r, tt.event, []byte(tt.payload))
+
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+
+			if e.Type != tt.event || e.Repo != tt.repo || e.RepoUrl != tt.repoUrl || e.Ref != tt.ref || e.Sha != tt.sha {
+				t.Errorf("Normalize() = {%s %s %s %s %s}, want {%s %s %s %s %s}", e.Type, e.Repo, e.RepoUrl, e.Ref, e.Sha,
+					tt.event, tt.repo, tt.repoUrl, tt.ref, tt.sha)
+			}
+
+			if string(e.Payload) != tt.payload {
+				t.Errorf("Normalize() changed the payload")
+			}
+		})
+	}
+}
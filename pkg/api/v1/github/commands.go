@@ -0,0 +1,114 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+var apiClient = &http.Client{Timeout: 10 * time.Second}
+
+// EventHeader returns the header X-GitHub-Event
+func (g *GitHub) EventHeader() string {
+	return eventHeader
+}
+
+// CommentCommand returns the command of a new comment of a pull request
+//
+// Note: GitHub sends the comments of pull requests as issue_comment events, the issue has the
+// field pull_request
+func (g *GitHub) CommentCommand(event string, payload []byte) *webhook.CommentCommand {
+	if event != "issue_comment" {
+		return nil
+	}
+
+	data := string(payload)
+
+	if gjson.Get(data, "action").String() != "created" || !gjson.Get(data, "issue.pull_request").Exists() {
+		return nil
+	}
+
+	command := webhook.ParseCommentCommand(gjson.Get(data, "comment.body").String())
+
+	if command == nil {
+		return nil
+	}
+
+	command.Author = gjson.Get(data, "comment.user.login").String()
+	command.Association = gjson.Get(data, "comment.author_association").String()
+	command.Repo = gjson.Get(data, "repository.full_name").String()
+	command.PullRequest = gjson.Get(data, "issue.number").Int()
+
+	return command
+}
+
+// PullRequestEvent gets the pull request from the API and returns a pull_request event with the
+// action synchronize, as if new commits were pushed
+func (g *GitHub) PullRequestEvent(ctx context.Context, command *webhook.CommentCommand, payload []byte) (string, []byte, error) {
+	url := fmt.Sprintf("%s/repos/%s/pulls/%d", strings.TrimRight(g.ApiUrl, "/"), command.Repo, command.PullRequest)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	if len(g.Token) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.Token))
+	}
+
+	res, err := apiClient.Do(req)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("github responded %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if !gjson.ValidBytes(body) {
+		return "", nil, fmt.Errorf("invalid pull request returned by github")
+	}
+
+	data := string(payload)
+
+	fields := map[string]interface{}{
+		"action":       "synchronize",
+		"number":       command.PullRequest,
+		"pull_request": json.RawMessage(body),
+	}
+
+	// Note: An empty json.RawMessage cannot be marshaled
+	for _, key := range []string{"repository", "sender"} {
+		if value := gjson.Get(data, key); value.Exists() {
+			fields[key] = json.RawMessage(value.Raw)
+		}
+	}
+
+	event, err := json.Marshal(fields)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "pull_request", event, nil
+}
@@ -1,208 +1,146 @@
 package github
 
 import (
-	"crypto/hmac"
 	"crypto/sha1"
-	"encoding/hex"
-	"errors"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"net/http"
-	"net/url"
 	"strings"
 
+	"github.com/tidwall/gjson"
+
 	"github.com/jaberchez/custom-tekton-listener/pkg/config"
-	"github.com/jaberchez/custom-tekton-listener/pkg/tekton"
 	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
 )
 
-type GitHub struct {
-	ID          string
-	HttpRequest *http.Request
-	CheckIps    bool
-	Payload     []byte
-	GithubEvent string
-}
-
-func (g *GitHub) HandleRequest() {
-	// Get query parameters
-	queryParams := g.HttpRequest.URL.Query()
-
-	if len(queryParams) == 0 {
-		utils.Log("ERROR", "found empty parameters in http query request")
-		return
-	}
-
-	var pipelineName, prefix string
+// Header with the type of event
+const eventHeader string = "X-GitHub-Event"
 
-	// Check if pipeline param exists in query string
-	//
-	// Note: "pipeline" and "prefix" are mandatory parameters
-	for i, item := range []string{"pipeline", "prefix"} {
-		paramFound, paramValue := getQueryParam(item, queryParams)
+// GitHub implements webhook.Provider and webhook.CommentCommandProvider
+type GitHub struct {
+	CheckIps bool
 
-		if !paramFound {
-			utils.Log("ERROR", fmt.Sprintf("%s param not found in query request", strings.ToUpper(item)))
-			return
-		}
+	// API used to get the pull requests to retest. Token is optional for public repositories
+	ApiUrl string
+	Token  string
+}
 
-		if len(paramValue) == 0 {
-			utils.Log("ERROR", fmt.Sprintf("found empty value in http query param %s", strings.ToUpper(item)))
-			return
-		}
+func (g *GitHub) Name() string {
+	return "github"
+}
 
-		if i == 0 {
-			pipelineName = strings.ToLower(paramValue)
-		} else {
-			// Remove the last - or _ (if exists)
-			if paramValue[len(paramValue)-1:] == "-" || paramValue[len(paramValue)-1:] == "_" {
-				paramValue = paramValue[0 : len(paramValue)-1]
-			}
+func (g *GitHub) Event(r *http.Request) (string, error) {
+	// Get X-GitHub-Event header
+	event := r.Header.Get(eventHeader)
 
-			prefix = strings.ToLower(paramValue)
-		}
+	if len(event) == 0 {
+		return "", fmt.Errorf("%s header not found", eventHeader)
 	}
 
-	// Get the configuration for this particuar pipeline
-	pipelineConfig := config.GetPipeline(pipelineName)
+	return event, nil
+}
 
-	if pipelineConfig == nil {
-		utils.Log("ERROR", fmt.Sprintf("pipeline %s not found in configuration", pipelineName))
-		return
-	}
+func (g *GitHub) DeliveryId(r *http.Request) string {
+	return r.Header.Get("X-GitHub-Delivery")
+}
 
-	// Check if this webhook is a secure webhook
-	//
-	// Get password por this type of pipeline
-	githubPass := pipelineConfig.GithubPassword
+func (g *GitHub) IsPing(event string) bool {
+	return event == "ping"
+}
 
-	if len(githubPass) == 0 {
-		// Password for this particular pipeline not found, try global password
-		githubPass = config.GetGlobalGithubPassword()
+func (g *GitHub) CheckSourceIp(r *http.Request) (bool, error) {
+	if !g.CheckIps {
+		return true, nil
 	}
 
-	if len(githubPass) > 0 {
-		// Is a secure webhook, check the signature
-		ok, err := g.isValidSignature(githubPass)
+	sourceIp, err := utils.GetIpFromRequest(r)
 
-		if err != nil {
-			utils.Log("ERROR", err.Error())
-			return
-		}
-
-		if !ok {
-			utils.Log("ERROR", "wrong webhook signature")
-			return
-		}
+	if err != nil {
+		return false, err
 	}
 
-	// Check if we should run a pipeline
-	pass, err := config.CheckWhenConditions(pipelineConfig.When, queryParams, g.HttpRequest, g.Payload)
+	allowed, err := utils.CheckGitHubIps(sourceIp)
 
 	if err != nil {
-		utils.Log("ERROR", err.Error())
-		return
+		return false, err
 	}
 
-	if !pass {
-		utils.Log("INFO", "pipelinerun is not launched because does not meet the when conditions")
-		return
+	if !allowed {
+		utils.LogContext(r.Context(), "ERROR", fmt.Sprintf("source IP %s not allowed", sourceIp))
 	}
 
-	// Create PipelineRun
-	pipelineRun := &tekton.PipelineRun{
-		ID:            g.ID,
-		PipelineName:  pipelineName,
-		Prefix:        prefix,
-		GitHubPayload: g.Payload,
-		GitHubEvent:   g.GithubEvent,
-		Workspaces:    pipelineConfig.Workspaces,
-		Resources:     pipelineConfig.Resources,
-	}
+	return allowed, nil
+}
 
-	// Service account
-	// Note: If service account is configured en global and particular pipeline, the service account
-	//       of pipeline takes precedence
-	serviceAccount := config.GetGlobalServiceAccount()
+func (g *GitHub) VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error) {
+	// Get password por this type of pipeline
+	githubPass := pipeline.GithubPassword
 
-	if len(pipelineConfig.ServiceAccount) > 0 {
-		serviceAccount = pipelineConfig.ServiceAccount
+	if len(githubPass) == 0 {
+		// Password for this particular pipeline not found, try global password
+		githubPass = config.GetGlobalGithubPassword()
 	}
 
-	if len(serviceAccount) > 0 {
-		pipelineRun.ServiceAccount = serviceAccount
+	if len(githubPass) == 0 {
+		// Not a secure webhook
+		return true, nil
 	}
 
-	// Set extra params
-	//
-	// Notes: - Extra params come from the query string and global and particular params from configmap
-	//        - The order is global custom data, particular custom data and query http params
-	extraParams := config.GetGlobalExtraParams()
+	return isValidSignature(r, payload, githubPass, config.GetSignatureAlgorithms(pipeline))
+}
 
-	// Note: If a key exists in global extra params is overwriten
-	for _, item := range pipelineConfig.ExtraParams {
-		extraParams[item.Name] = item.Value
-	}
+func (g *GitHub) Normalize(r *http.Request, event string, payload []byte) (*webhook.Event, error) {
+	data := string(payload)
 
-	for k, v := range queryParams {
-		// Store the parameters as they have been set
-		extraParams[k] = v[0]
+	e := &webhook.Event{
+		Type:    event,
+		Payload: payload,
+		Repo:    gjson.Get(data, "repository.full_name").String(),
+		RepoUrl: gjson.Get(data, "repository.clone_url").String(),
+		Ref:     gjson.Get(data, "ref").String(),
+		Sha:     gjson.Get(data, "after").String(),
 	}
 
-	pipelineRun.ExtraParams = extraParams
-
-	err = pipelineRun.Start()
-
-	if err != nil {
-		utils.Log("ERROR", err.Error())
+	if pr := gjson.Get(data, "pull_request"); pr.Exists() {
+		e.Ref = "refs/heads/" + pr.Get("head.ref").String()
+		e.Sha = pr.Get("head.sha").String()
+		e.PullRequest = pr.Get("number").Int()
 	}
 
-	utils.Log("INFO", "ok launched pipelinerun")
+	return e, nil
 }
 
-func getQueryParam(name string, params url.Values) (bool, string) {
-	var paramValues []string
-	var paramFound bool
-	var paramValue string
-
-	for k, val := range params {
-		keyTmp := strings.ToLower(k)
+func isValidSignature(r *http.Request, payload []byte, secret string, algorithms []string) (bool, error) {
+	// Prefer X-Hub-Signature-256 (HMAC-SHA256). The legacy X-Hub-Signature (HMAC-SHA1)
+	// is only checked when sha1 is allowed in configuration
+	if config.IsSignatureAlgorithmAllowed(config.SignatureAlgorithmSha256, algorithms) {
+		signatureHeader := r.Header.Get("X-Hub-Signature-256")
 
-		if keyTmp == name {
-			paramFound = true
-			paramValues = val
-			break
+		if len(signatureHeader) > 0 {
+			return isValidHmac(signatureHeader, config.SignatureAlgorithmSha256, sha256.New, secret, payload)
 		}
 	}
 
-	if len(paramValues) > 0 {
-		// Return the first value
-		paramValue = paramValues[0]
+	if config.IsSignatureAlgorithmAllowed(config.SignatureAlgorithmSha1, algorithms) {
+		signatureHeader := r.Header.Get("X-Hub-Signature")
+
+		if len(signatureHeader) > 0 {
+			return isValidHmac(signatureHeader, config.SignatureAlgorithmSha1, sha1.New, secret, payload)
+		}
 	}
 
-	return paramFound, paramValue
+	return false, fmt.Errorf("signature header not found for allowed algorithms: %s", strings.Join(algorithms, ", "))
 }
 
-func (g *GitHub) isValidSignature(secret string) (bool, error) {
-	// Get X-Hub-Signature header
-	signatureHeader := g.HttpRequest.Header.Get("X-Hub-Signature")
-
-	if len(signatureHeader) == 0 {
-		return false, errors.New("X-Hub-Signature header not found")
-	}
-
+// isValidHmac checks a signature header with the format algorithm=hexdigest
+func isValidHmac(signatureHeader string, algorithm string, hashFunc func() hash.Hash, secret string, payload []byte) (bool, error) {
 	gotHash := strings.SplitN(signatureHeader, "=", 2)
 
-	if gotHash[0] != "sha1" {
-		return false, errors.New("sha1 not found")
+	if len(gotHash) != 2 || gotHash[0] != algorithm {
+		return false, fmt.Errorf("%s not found in signature header", algorithm)
 	}
 
-	hash := hmac.New(sha1.New, []byte(secret))
-
-	if _, err := hash.Write(g.Payload); err != nil {
-		return false, fmt.Errorf("cannot compute the HMAC for request: %s", err)
-	}
-
-	expectedHash := hex.EncodeToString(hash.Sum(nil))
-
-	return gotHash[1] == expectedHash, nil
+	return webhook.IsValidHmac(gotHash[1], hashFunc, secret, payload)
 }
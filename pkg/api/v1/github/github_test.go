@@ -0,0 +1,297 @@
+package github
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+const (
+	testSecret  string = "s3cret"
+	testPayload string = `{"ref":"refs/heads/main"}`
+)
+
+func sign(hashFunc func() hash.Hash, secret string, payload string) string {
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write([]byte(payload))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	sha256Sum := sign(sha256.New, testSecret, testPayload)
+	sha1Sum := sign(sha1.New, testSecret, testPayload)
+
+	tests := []struct {
+		name       string
+		secret     string
+		algorithms []string
+		headers    map[string]string
+		want       bool
+		wantErr    bool
+	}{
+		{
+			name:    "no secret",
+			want:    true,
+			headers: map[string]string{},
+		},
+		{
+			name:    "sha256 valid",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sha256Sum},
+			want:    true,
+		},
+		{
+			name:    "sha256 with another secret",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "other", testPayload)},
+		},
+		{
+			name:    "sha256 of another payload",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, testSecret, "{}")},
+		},
+		{
+			name:    "sha256 wrong length",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sha256Sum[:32]},
+		},
+		{
+			name:    "sha256 empty digest",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256="},
+		},
+		{
+			name:    "sha256 malformed hex",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=zz" + sha256Sum[2:]},
+			wantErr: true,
+		},
+		{
+			name:    "sha256 without algorithm",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": sha256Sum},
+			wantErr: true,
+		},
+		{
+			name:    "sha256 with sha1 prefix",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature-256": "sha1=" + sha256Sum},
+			wantErr: true,
+		},
+		{
+			name:    "missing headers",
+			secret:  testSecret,
+			headers: map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "sha1 not allowed by default",
+			secret:  testSecret,
+			headers: map[string]string{"X-Hub-Signature": "sha1=" + sha1Sum},
+			wantErr: true,
+		},
+		{
+			name:       "sha1 allowed",
+			secret:     testSecret,
+			algorithms: []string{config.SignatureAlgorithmSha256, config.SignatureAlgorithmSha1},
+			headers:    map[string]string{"X-Hub-Signature": "sha1=" + sha1Sum},
+			want:       true,
+		},
+		{
+			name:       "sha1 wrong length",
+			secret:     testSecret,
+			algorithms: []string{config.SignatureAlgorithmSha1},
+			headers:    map[string]string{"X-Hub-Signature": "sha1=" + sha1Sum[:20]},
+		},
+		{
+			name:       "sha256 preferred over sha1",
+			secret:     testSecret,
+			algorithms: []string{config.SignatureAlgorithmSha256, config.SignatureAlgorithmSha1},
+			headers: map[string]string{
+				"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "other", testPayload),
+				"X-Hub-Signature":     "sha1=" + sha1Sum,
+			},
+		},
+		{
+			name:       "sha256 ignored when only sha1 is allowed",
+			secret:     testSecret,
+			algorithms: []string{config.SignatureAlgorithmSha1},
+			headers:    map[string]string{"X-Hub-Signature-256": "sha256=" + sha256Sum},
+			wantErr:    true,
+		},
+	}
+
+	g := &GitHub{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/github", strings.NewReader(testPayload))
+
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			pipeline := &config.Pipeline{
+				GithubPassword:      tt.secret,
+				SignatureAlgorithms: tt.algorithms,
+			}
+
+			got, err := g.VerifySignature(r, []byte(testPayload), pipeline)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommentCommand(t *testing.T) {
+	comment := func(action string, pullRequest bool, body string) string {
+		issue := `{"number": 42}`
+
+		if pullRequest {
+			issue = `{"number": 42, "pull_request": {"url": "https://api.github.com/repos/owner/repo/pulls/42"}}`
+		}
+
+		return fmt.Sprintf(`{
+			"action": %q,
+			"issue": %s,
+			"comment": {"body": %q, "user": {"login": "octocat"}, "author_association": "MEMBER"},
+			"repository": {"full_name": "owner/repo"}
+		}`, action, issue, body)
+	}
+
+	tests := []struct {
+		name    string
+		event   string
+		payload string
+		want    *webhook.CommentCommand
+	}{
+		{
+			name:    "retest",
+			event:   "issue_comment",
+			payload: comment("created", true, "/retest build"),
+			want: &webhook.CommentCommand{Name: webhook.CommandRetest, Pipeline: "build", Author: "octocat",
+				Association: "MEMBER", Repo: "owner/repo", PullRequest: 42},
+		},
+		{
+			name:    "comment without command",
+			event:   "issue_comment",
+			payload: comment("created", true, "LGTM"),
+		},
+		{
+			name:    "comment edited",
+			event:   "issue_comment",
+			payload: comment("edited", true, "/retest"),
+		},
+		{
+			name:    "comment of an issue",
+			event:   "issue_comment",
+			payload: comment("created", false, "/retest"),
+		},
+		{
+			name:    "another event",
+			event:   "pull_request_review_comment",
+			payload: comment("created", true, "/retest"),
+		},
+	}
+
+	g := &GitHub{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.CommentCommand(tt.event, []byte(tt.payload)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CommentCommand() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPullRequestEvent(t *testing.T) {
+	const pullRequest string = `{"number": 7, "head": {"sha": "6dcb09b5b57875f334f61aebed695e2e4193db5e"}}`
+
+	var path, authorization string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+
+		w.Write([]byte(pullRequest))
+	}))
+
+	defer server.Close()
+
+	g := &GitHub{ApiUrl: server.URL + "/", Token: "t0ken"}
+
+	command := &webhook.CommentCommand{Name: webhook.CommandRetest, Repo: "owner/repo", PullRequest: 7}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{
+			name:    "repository and sender",
+			payload: `{"repository": {"full_name": "owner/repo"}, "sender": {"login": "octocat"}}`,
+			want: `{"action": "synchronize", "number": 7, "pull_request": ` + pullRequest +
+				`, "repository": {"full_name": "owner/repo"}, "sender": {"login": "octocat"}}`,
+		},
+		{
+			name:    "without sender",
+			payload: `{"repository": {"full_name": "owner/repo"}}`,
+			want:    `{"action": "synchronize", "number": 7, "pull_request": ` + pullRequest + `, "repository": {"full_name": "owner/repo"}}`,
+		},
+		{
+			name:    "without repository and sender",
+			payload: `{}`,
+			want:    `{"action": "synchronize", "number": 7, "pull_request": ` + pullRequest + `}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, payload, err := g.PullRequestEvent(context.Background(), command, []byte(tt.payload))
+
+			if err != nil {
+				t.Fatalf("PullRequestEvent() error = %v", err)
+			}
+
+			if event != "pull_request" {
+				t.Errorf("PullRequestEvent() event = %s, want pull_request", event)
+			}
+
+			var got, want interface{}
+
+			if err := json.Unmarshal(payload, &got); err != nil {
+				t.Fatalf("malformed payload %s: %v", payload, err)
+			}
+
+			json.Unmarshal([]byte(tt.want), &want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("PullRequestEvent() payload = %s, want %s", payload, tt.want)
+			}
+
+			if path != "/repos/owner/repo/pulls/7" || authorization != "Bearer t0ken" {
+				t.Errorf("request %s with Authorization %q", path, authorization)
+			}
+		})
+	}
+}
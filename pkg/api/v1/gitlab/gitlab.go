@@ -0,0 +1,94 @@
+package gitlab
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+// GitLab implements webhook.Provider
+type GitLab struct{}
+
+func (g *GitLab) Name() string {
+	return "gitlab"
+}
+
+func (g *GitLab) Event(r *http.Request) (string, error) {
+	// Get X-Gitlab-Event header
+	event := r.Header.Get("X-Gitlab-Event")
+
+	if len(event) == 0 {
+		return "", errors.New("X-Gitlab-Event header not found")
+	}
+
+	return event, nil
+}
+
+func (g *GitLab) DeliveryId(r *http.Request) string {
+	return r.Header.Get("X-Gitlab-Event-UUID")
+}
+
+// IsPing returns always false, GitLab does not have a ping event
+func (g *GitLab) IsPing(event string) bool {
+	return false
+}
+
+func (g *GitLab) CheckSourceIp(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+func (g *GitLab) VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error) {
+	// Get token for this type of pipeline
+	gitlabToken := pipeline.GitlabToken
+
+	if len(gitlabToken) == 0 {
+		// Token for this particular pipeline not found, try global token
+		gitlabToken = config.GetGlobalGitlabToken()
+	}
+
+	if len(gitlabToken) == 0 {
+		// Not a secure webhook
+		return true, nil
+	}
+
+	return isValidToken(r, gitlabToken)
+}
+
+func (g *GitLab) Normalize(r *http.Request, event string, payload []byte) (*webhook.Event, error) {
+	data := string(payload)
+
+	e := &webhook.Event{
+		Type:    event,
+		Payload: payload,
+		Repo:    gjson.Get(data, "project.path_with_namespace").String(),
+		RepoUrl: gjson.Get(data, "project.git_http_url").String(),
+		Ref:     gjson.Get(data, "ref").String(),
+		Sha:     gjson.Get(data, "checkout_sha").String(),
+	}
+
+	if gjson.Get(data, "object_kind").String() == "merge_request" {
+		e.Ref = "refs/heads/" + gjson.Get(data, "object_attributes.source_branch").String()
+		e.Sha = gjson.Get(data, "object_attributes.last_commit.id").String()
+	}
+
+	return e, nil
+}
+
+// isValidToken checks the X-Gitlab-Token header. GitLab does not sign the payload, it
+// sends the secret token as is
+func isValidToken(r *http.Request, token string) (bool, error) {
+	// Get X-Gitlab-Token header
+	tokenHeader := r.Header.Get("X-Gitlab-Token")
+
+	if len(tokenHeader) == 0 {
+		return false, errors.New("X-Gitlab-Token header not found")
+	}
+
+	// Constant time comparison
+	return subtle.ConstantTimeCompare([]byte(tokenHeader), []byte(token)) == 1, nil
+}
@@ -0,0 +1,160 @@
+package gitlab
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+)
+
+func TestVerifySignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		headers map[string]string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:    "no token",
+			headers: map[string]string{},
+			want:    true,
+		},
+		{
+			name:    "valid",
+			token:   "s3cret",
+			headers: map[string]string{"X-Gitlab-Token": "s3cret"},
+			want:    true,
+		},
+		{
+			name:    "another token",
+			token:   "s3cret",
+			headers: map[string]string{"X-Gitlab-Token": "other"},
+		},
+		{
+			name:    "prefix of the token",
+			token:   "s3cret",
+			headers: map[string]string{"X-Gitlab-Token": "s3c"},
+		},
+		{
+			name:    "missing header",
+			token:   "s3cret",
+			headers: map[string]string{},
+			wantErr: true,
+		},
+	}
+
+	g := &GitLab{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/gitlab", nil)
+
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			got, err := g.VerifySignature(r, nil, &config.Pipeline{GitlabToken: tt.token})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		payload string
+		repo    string
+		repoUrl string
+		ref     string
+		sha     string
+	}{
+		{
+			name:  "push",
+			event: "Push Hook",
+			payload: `{
+				"object_kind": "push",
+				"ref": "refs/heads/main",
+				"checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				"project": {
+					"path_with_namespace": "group/project",
+					"git_http_url": "https://gitlab.example.com/group/project.git"
+				}
+			}`,
+			repo:    "group/project",
+			repoUrl: "https://gitlab.example.com/group/project.git",
+			ref:     "refs/heads/main",
+			sha:     "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		},
+		{
+			name:  "tag push",
+			event: "Tag Push Hook",
+			payload: `{
+				"object_kind": "tag_push",
+				"ref": "refs/tags/v1.0.0",
+				"checkout_sha": "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+				"project": {"path_with_namespace": "group/project"}
+			}`,
+			repo: "group/project",
+			ref:  "refs/tags/v1.0.0",
+			sha:  "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+		},
+		{
+			name:  "merge request",
+			event: "Merge Request Hook",
+			payload: `{
+				"object_kind": "merge_request",
+				"project": {
+					"path_with_namespace": "group/project",
+					"git_http_url": "https://gitlab.example.com/group/project.git"
+				},
+				"object_attributes": {
+					"iid": 7,
+					"source_branch": "feature",
+					"target_branch": "main",
+					"last_commit": {"id": "b83d6e391c22777fca1ed3012fce84f633d7fed0"}
+				}
+			}`,
+			repo:    "group/project",
+			repoUrl: "https://gitlab.example.com/group/project.git",
+			ref:     "refs/heads/feature",
+			sha:     "b83d6e391c22777fca1ed3012fce84f633d7fed0",
+		},
+		{
+			name:    "unknown fields",
+			event:   "Note Hook",
+			payload: `{"object_kind": "note"}`,
+		},
+	}
+
+	g := &GitLab{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/gitlab", nil)
+
+			e, err := g.Normalize(r, tt.event, []byte(tt.payload))
+
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+
+			if e.Type != tt.event || e.Repo != tt.repo || e.RepoUrl != tt.repoUrl || e.Ref != tt.ref || e.Sha != tt.sha {
+				t.Errorf("Normalize() = {%s %s %s %s %s}, want {%s %s %s %s %s}", e.Type, e.Repo, e.RepoUrl, e.Ref, e.Sha,
+					tt.event, tt.repo, tt.repoUrl, tt.ref, tt.sha)
+			}
+
+			if string(e.Payload) != tt.payload {
+				t.Errorf("Normalize() changed the payload")
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+const (
+	// Allowed values of client authentication
+	ClientAuthRequire  string = "require"
+	ClientAuthOptional string = "optional"
+
+	defaultReloadInterval time.Duration = 30 * time.Second
+
+	// Keys of a Kubernetes TLS Secret
+	secretCertKey string = "tls.crt"
+	secretKeyKey  string = "tls.key"
+)
+
+// Options configures where the certificate is read from. The certificate is read from
+// CertFile and KeyFile or from the Kubernetes TLS Secret SecretName
+type Options struct {
+	CertFile   string
+	KeyFile    string
+	SecretName string
+	Namespace  string
+
+	// If ClientCAFile is set, the certificate of the clients is verified with this CA only if
+	// given (ClientAuthOptional, default), or the clients must present one (ClientAuthRequire)
+	//
+	// Note: Optional is the default because the probes of the kubelet do not send a certificate
+	ClientCAFile string
+	ClientAuth   string
+
+	ReloadInterval time.Duration
+}
+
+// Reloader keeps the active TLS configuration and reloads it when the certificate, the key
+// or the client CA change (e.g. renewed by cert-manager)
+type Reloader struct {
+	opts Options
+
+	mutex     sync.RWMutex
+	tlsConfig *tls.Config
+	checksum  [sha256.Size]byte
+}
+
+// NewReloader checks the options and loads the certificate
+func NewReloader(opts Options) (*Reloader, error) {
+	if len(opts.SecretName) > 0 {
+		if len(opts.CertFile) > 0 || len(opts.KeyFile) > 0 {
+			return nil, errors.New("the certificate must be read from files or from a Secret, not both")
+		}
+	} else if len(opts.CertFile) == 0 || len(opts.KeyFile) == 0 {
+		return nil, errors.New("both certificate file and key file are required")
+	}
+
+	if len(opts.ClientAuth) == 0 {
+		opts.ClientAuth = ClientAuthOptional
+	}
+
+	opts.ClientAuth = strings.ToLower(opts.ClientAuth)
+
+	if opts.ClientAuth != ClientAuthRequire && opts.ClientAuth != ClientAuthOptional {
+		return nil, fmt.Errorf("client auth %s not allowed (allowed values: %s, %s)", opts.ClientAuth,
+			ClientAuthRequire, ClientAuthOptional)
+	}
+
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = defaultReloadInterval
+	}
+
+	r := &Reloader{
+		opts: opts,
+	}
+
+	_, err := r.reload()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// TLSConfig returns the configuration for the http server. The certificate and the client CA
+// are taken from the active configuration in each handshake, so the reloads do not need to
+// restart the server
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.getTLSConfig().Certificates[0], nil
+		},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.getTLSConfig(), nil
+		},
+	}
+}
+
+// Run checks periodically if the certificate changed until stopCh is closed. If the new
+// certificate is not valid, the active one is kept
+func (r *Reloader) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			changed, err := r.reload()
+
+			if err != nil {
+				utils.Log("ERROR", fmt.Sprintf("unable to reload tls certificate, keeping the active one: %s", err.Error()))
+				continue
+			}
+
+			if changed {
+				utils.Log("INFO", "tls certificate reloaded")
+			}
+		}
+	}
+}
+
+func (r *Reloader) getTLSConfig() *tls.Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.tlsConfig
+}
+
+// reload reads the certificate and replaces the active configuration if it changed
+func (r *Reloader) reload() (bool, error) {
+	certPEM, keyPEM, err := r.readCertificate()
+
+	if err != nil {
+		return false, err
+	}
+
+	var caPEM []byte
+
+	if len(r.opts.ClientCAFile) > 0 {
+		caPEM, err = ioutil.ReadFile(r.opts.ClientCAFile)
+
+		if err != nil {
+			return false, fmt.Errorf("unable to read client CA: %s", err.Error())
+		}
+	}
+
+	checksum := sha256.Sum256(bytes.Join([][]byte{certPEM, keyPEM, caPEM}, []byte{0}))
+
+	r.mutex.RLock()
+	unchanged := r.tlsConfig != nil && r.checksum == checksum
+	r.mutex.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+
+	if err != nil {
+		return false, fmt.Errorf("invalid certificate: %s", err.Error())
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return false, fmt.Errorf("no valid certificates found in client CA %s", r.opts.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		if r.opts.ClientAuth == ClientAuthOptional {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tlsConfig = tlsConfig
+	r.checksum = checksum
+
+	return true, nil
+}
+
+// readCertificate returns the PEM encoded certificate and key
+func (r *Reloader) readCertificate() ([]byte, []byte, error) {
+	if len(r.opts.SecretName) > 0 {
+		secret, err := k8s.GetSecret(r.opts.SecretName, r.opts.Namespace)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read TLS Secret %s: %s", r.opts.SecretName, err.Error())
+		}
+
+		certPEM, ok := secret.Data[secretCertKey]
+
+		if !ok {
+			return nil, nil, fmt.Errorf("key %s not found in Secret %s", secretCertKey, r.opts.SecretName)
+		}
+
+		keyPEM, ok := secret.Data[secretKeyKey]
+
+		if !ok {
+			return nil, nil, fmt.Errorf("key %s not found in Secret %s", secretKeyKey, r.opts.SecretName)
+		}
+
+		return certPEM, keyPEM, nil
+	}
+
+	certPEM, err := ioutil.ReadFile(r.opts.CertFile)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read certificate: %s", err.Error())
+	}
+
+	keyPEM, err := ioutil.ReadFile(r.opts.KeyFile)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read key: %s", err.Error())
+	}
+
+	return certPEM, keyPEM, nil
+}
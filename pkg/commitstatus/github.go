@@ -0,0 +1,202 @@
+package commitstatus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+	"github.com/jaberchez/custom-tekton-listener/pkg/watcher"
+)
+
+const (
+	DefaultGitHubApiUrl string = "https://api.github.com"
+	DefaultContext      string = "tekton/{{ .Pipeline }}"
+
+	// Attempts to post a status, the server errors and the connection errors are retried
+	maxAttempts int = 3
+
+	// Maximum length of the description allowed by GitHub
+	maxDescriptionLength int = 140
+)
+
+// GitHub sets the state of the PipelineRuns created from GitHub events as commit statuses of
+// the head commit
+type GitHub struct {
+	apiUrl    string
+	token     string
+	context   *template.Template
+	targetUrl *template.Template // Optional
+	client    *http.Client
+}
+
+// NewGitHub returns the subscriber. apiUrl is the base url of the API (e.g. https://api.github.com
+// or https://github.example.com/api/v3). contextTemplate and targetUrlTemplate are executed with
+// the watcher.Run, e.g. a target url with the Tekton Dashboard:
+//
+//	https://tekton.example.com/#/namespaces/{{ .Namespace }}/pipelineruns/{{ .Name }}
+func NewGitHub(apiUrl string, token string, contextTemplate string, targetUrlTemplate string) (*GitHub, error) {
+	if len(apiUrl) == 0 {
+		apiUrl = DefaultGitHubApiUrl
+	}
+
+	if len(contextTemplate) == 0 {
+		contextTemplate = DefaultContext
+	}
+
+	g := &GitHub{
+		apiUrl: strings.TrimRight(apiUrl, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var err error
+
+	g.context, err = template.New("context").Funcs(sprig.TxtFuncMap()).Parse(contextTemplate)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid context template: %s", err.Error())
+	}
+
+	if len(targetUrlTemplate) > 0 {
+		g.targetUrl, err = template.New("targetUrl").Funcs(sprig.TxtFuncMap()).Parse(targetUrlTemplate)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid target url template: %s", err.Error())
+		}
+	}
+
+	return g, nil
+}
+
+func (g *GitHub) Name() string {
+	return "github-status"
+}
+
+func (g *GitHub) Wants(run *watcher.Run) bool {
+	return run.Provider == "github" && len(run.Repo) > 0 && len(run.Sha) > 0
+}
+
+// status is the body of the request to create a commit status
+type status struct {
+	State       string `json:"state"`
+	TargetUrl   string `json:"target_url,omitempty"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+func (g *GitHub) Notify(ctx context.Context, run *watcher.Run) error {
+	st := &status{}
+
+	switch run.State {
+	case watcher.StatePending:
+		st.State, st.Description = "pending", "Waiting for other pipelineruns to finish"
+	case watcher.StateRunning:
+		st.State, st.Description = "pending", "Running"
+	case watcher.StateSucceeded:
+		st.State, st.Description = "success", "Succeeded"
+	case watcher.StateCancelled:
+		st.State, st.Description = "error", "Cancelled"
+	default:
+		st.State, st.Description = "failure", "Failed"
+	}
+
+	if run.IsDone() && len(run.Message) > 0 {
+		st.Description = fmt.Sprintf("%s: %s", st.Description, run.Message)
+	}
+
+	if len(st.Description) > maxDescriptionLength {
+		st.Description = st.Description[:maxDescriptionLength-3] + "..."
+	}
+
+	var err error
+
+	st.Context, err = execute(g.context, run)
+
+	if err != nil {
+		return fmt.Errorf("unable to render the context: %s", err.Error())
+	}
+
+	if g.targetUrl != nil {
+		st.TargetUrl, err = execute(g.targetUrl, run)
+
+		if err != nil {
+			return fmt.Errorf("unable to render the target url: %s", err.Error())
+		}
+	}
+
+	body, err := json.Marshal(st)
+
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/statuses/%s", g.apiUrl, run.Repo, run.Sha)
+
+	for attempt := 1; ; attempt++ {
+		var retry bool
+
+		retry, err = g.post(ctx, url, body)
+
+		if err == nil {
+			utils.LogContext(ctx, "DEBUG", fmt.Sprintf("commit status %s set to %s in %s@%s", st.Context, st.State, run.Repo, run.Sha))
+			return nil
+		}
+
+		if !retry || attempt >= maxAttempts {
+			return err
+		}
+
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// post sends the request and returns whether it can be retried if it fails
+func (g *GitHub) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.token))
+
+	res, err := g.client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	msg, _ := ioutil.ReadAll(res.Body)
+
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests,
+		fmt.Errorf("github responded %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+func execute(t *template.Template, run *watcher.Run) (string, error) {
+	var out bytes.Buffer
+
+	err := t.Execute(&out, run)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
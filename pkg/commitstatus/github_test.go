@@ -0,0 +1,234 @@
+package commitstatus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/watcher"
+)
+
+// statusServer is a stub of the API of GitHub that records the requests
+type statusServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	requests []*http.Request
+	statuses []status
+	codes    []int // Status codes to respond, 201 when they run out
+}
+
+func newStatusServer(t *testing.T, codes ...int) *statusServer {
+	s := &statusServer{codes: codes}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var st status
+
+		if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+			t.Errorf("malformed status: %v", err)
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.requests = append(s.requests, r)
+		s.statuses = append(s.statuses, st)
+
+		code := http.StatusCreated
+
+		if len(s.codes) > 0 {
+			code, s.codes = s.codes[0], s.codes[1:]
+		}
+
+		w.WriteHeader(code)
+		w.Write([]byte(`{"message": "stub"}`))
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func newRun(state string) *watcher.Run {
+	return &watcher.Run{
+		Name:      "build-abc",
+		Namespace: "pipelines",
+		Pipeline:  "build",
+		Provider:  "github",
+		Repo:      "owner/repo",
+		Sha:       "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+		State:     state,
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		name        string
+		run         *watcher.Run
+		state       string
+		description string
+	}{
+		{
+			name:        "pending",
+			run:         newRun(watcher.StatePending),
+			state:       "pending",
+			description: "Waiting for other pipelineruns to finish",
+		},
+		{
+			name:        "running",
+			run:         newRun(watcher.StateRunning),
+			state:       "pending",
+			description: "Running",
+		},
+		{
+			name:        "succeeded",
+			run:         newRun(watcher.StateSucceeded),
+			state:       "success",
+			description: "Succeeded",
+		},
+		{
+			name: "failed",
+			run: func() *watcher.Run {
+				run := newRun(watcher.StateFailed)
+				run.Message = "Tasks Completed: 2 (Failed: 1, Cancelled 0), Skipped: 0"
+				return run
+			}(),
+			state:       "failure",
+			description: "Failed: Tasks Completed: 2 (Failed: 1, Cancelled 0), Skipped: 0",
+		},
+		{
+			name:        "cancelled",
+			run:         newRun(watcher.StateCancelled),
+			state:       "error",
+			description: "Cancelled",
+		},
+		{
+			name: "long message",
+			run: func() *watcher.Run {
+				run := newRun(watcher.StateFailed)
+				run.Message = strings.Repeat("x", 200)
+				return run
+			}(),
+			state:       "failure",
+			description: "Failed: " + strings.Repeat("x", maxDescriptionLength-len("Failed: ")-3) + "...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStatusServer(t)
+
+			g, err := NewGitHub(server.URL+"/api/v3/", "t0ken", "", "https://tekton.example.com/#/namespaces/{{ .Namespace }}/pipelineruns/{{ .Name }}")
+
+			if err != nil {
+				t.Fatalf("NewGitHub() error = %v", err)
+			}
+
+			err = g.Notify(context.Background(), tt.run)
+
+			if err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			if len(server.requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(server.requests))
+			}
+
+			r := server.requests[0]
+
+			if r.Method != http.MethodPost || r.URL.Path != "/api/v3/repos/owner/repo/statuses/6dcb09b5b57875f334f61aebed695e2e4193db5e" {
+				t.Errorf("request %s %s", r.Method, r.URL.Path)
+			}
+
+			if got := r.Header.Get("Authorization"); got != "Bearer t0ken" {
+				t.Errorf("Authorization = %q, want the token", got)
+			}
+
+			want := status{
+				State:       tt.state,
+				TargetUrl:   "https://tekton.example.com/#/namespaces/pipelines/pipelineruns/build-abc",
+				Description: tt.description,
+				Context:     "tekton/build",
+			}
+
+			if server.statuses[0] != want {
+				t.Errorf("status = %+v, want %+v", server.statuses[0], want)
+			}
+		})
+	}
+}
+
+func TestNotifyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		codes    []int
+		requests int
+		wantErr  bool
+	}{
+		{"server error retried", []int{http.StatusBadGateway}, 2, false},
+		{"rate limit retried", []int{http.StatusTooManyRequests}, 2, false},
+		{"client error", []int{http.StatusUnprocessableEntity}, 1, true},
+		{"not found", []int{http.StatusNotFound}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStatusServer(t, tt.codes...)
+
+			g, err := NewGitHub(server.URL, "t0ken", "ci/{{ .Pipeline | upper }}", "")
+
+			if err != nil {
+				t.Fatalf("NewGitHub() error = %v", err)
+			}
+
+			err = g.Notify(context.Background(), newRun(watcher.StateRunning))
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(server.requests) != tt.requests {
+				t.Errorf("%d requests, want %d", len(server.requests), tt.requests)
+			}
+
+			if st := server.statuses[0]; st.Context != "ci/BUILD" || len(st.TargetUrl) > 0 {
+				t.Errorf("status = %+v, want the context ci/BUILD without target url", st)
+			}
+		})
+	}
+}
+
+func TestWants(t *testing.T) {
+	g, err := NewGitHub("", "t0ken", "", "")
+
+	if err != nil {
+		t.Fatalf("NewGitHub() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(run *watcher.Run)
+		want bool
+	}{
+		{"github", func(run *watcher.Run) {}, true},
+		{"another provider", func(run *watcher.Run) { run.Provider = "gitlab" }, false},
+		{"without repo", func(run *watcher.Run) { run.Repo = "" }, false},
+		{"without sha", func(run *watcher.Run) { run.Sha = "" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newRun(watcher.StateRunning)
+
+			tt.edit(run)
+
+			if got := g.Wants(run); got != tt.want {
+				t.Errorf("Wants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
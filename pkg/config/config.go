@@ -11,11 +11,13 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/Masterminds/sprig"
 	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
 	"github.com/tidwall/gjson"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -25,83 +27,280 @@ const (
 	ConfigmapType             string = "configmap"
 	SecretType                string = "secret"
 
-	whenKindHeader  string = "header"
-	whenKindPayload string = "payload"
-	whenKindQuery   string = "query"
+	whenKindHeader     string = "header"
+	whenKindPayload    string = "payload"
+	whenKindQuery      string = "query"
+	whenKindCloudEvent string = "cloudevent"
 
 	valueOperatorEqual       string = "equal"
 	valueOperatorNotEqual    string = "notequal"
 	valueOperatorContains    string = "contains"
 	valueOperatorNotContains string = "notcontains"
+
+	ResponseModeAsync string = "async"
+	ResponseModeSync  string = "sync"
+
+	// What to do when a delivery is received again (e.g. "Redeliver" in GitHub)
+	RedeliveryPolicySkip  string = "skip"
+	RedeliveryPolicyAllow string = "allow"
+
+	// What to do with the older PipelineRuns with the same concurrency key
+	ConcurrencyPolicyCancel string = "cancel"
+	ConcurrencyPolicyDelete string = "delete"
+
+	// Sinks of the notifications sent when the PipelineRuns finish
+	NotificationTypeWebhook string = "webhook"
+	NotificationTypeSlack   string = "slack"
+	NotificationTypeSmtp    string = "smtp"
+
+	// States of the PipelineRuns that can be notified
+	NotifyStateSucceeded string = "succeeded"
+	NotifyStateFailed    string = "failed"
+	NotifyStateCancelled string = "cancelled"
+
+	// Below the timeout of GitHub webhooks (10 seconds)
+	defaultSyncTimeout time.Duration = 8 * time.Second
+
+	// Timeout to write the response of the server of the webhooks. The synchronous requests must
+	// respond before it, otherwise the caller does not receive the response
+	WriteTimeout time.Duration = 15 * time.Second
+
+	SignatureAlgorithmSha256 string = "sha256"
+	SignatureAlgorithmSha1   string = "sha1"
 )
 
 var (
-	configuration config
+	// Active configuration, it is replaced when the configuration is reloaded
+	configuration      *config = &config{}
+	configurationMutex sync.RWMutex
+
+	// Only one reload at a time
+	reloadMutex sync.Mutex
 
 	workspacesTypes []string = []string{VolumeClaimTemplateType, PersistentVolumeClaimType,
 		EmptyDirType, ConfigmapType, SecretType}
 
-	whenKinds []string = []string{whenKindHeader, whenKindPayload, whenKindQuery}
+	whenKinds []string = []string{whenKindHeader, whenKindPayload, whenKindQuery, whenKindCloudEvent}
 
 	valuesOperators []string = []string{valueOperatorEqual, valueOperatorNotEqual,
 		valueOperatorContains, valueOperatorNotContains}
+
+	signatureAlgorithms []string = []string{SignatureAlgorithmSha256, SignatureAlgorithmSha1}
+
+	responseModes []string = []string{ResponseModeAsync, ResponseModeSync}
+
+	redeliveryPolicies []string = []string{RedeliveryPolicySkip, RedeliveryPolicyAllow}
+
+	concurrencyPolicies []string = []string{ConcurrencyPolicyCancel, ConcurrencyPolicyDelete}
+
+	notificationTypes []string = []string{NotificationTypeWebhook, NotificationTypeSlack, NotificationTypeSmtp}
+
+	notifyStates []string = []string{NotifyStateSucceeded, NotifyStateFailed, NotifyStateCancelled}
+
+	// Associations of the commenters with the repository (as reported by GitHub) allowed to run
+	// commands in pull request comments
+	commentAssociations []string = []string{"OWNER", "MEMBER", "COLLABORATOR", "CONTRIBUTOR",
+		"FIRST_TIME_CONTRIBUTOR", "FIRST_TIMER", "NONE"}
+
+	defaultCommentAssociations []string = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+	// Only the failures are notified unless states is set
+	defaultNotifyStates []string = []string{NotifyStateFailed}
+
+	// Only HMAC-SHA256 is accepted unless sha1 is explicitly allowed
+	defaultSignatureAlgorithms []string = []string{SignatureAlgorithmSha256}
 )
 
 // See the file configmap-config.yaml to check the configuration
 type config struct {
-	GlobalGitHubSecretName string      `yaml:"globalGithubSecretName",ommitempty`
-	GlobalExtraParams      []ParamItem `yaml:"globalExtraParams",ommitempty`
-	GlobalServiceAccount   string      `yaml:"globalServiceAccount",ommitempty`
-	GlobalGithubPassword   string
-	Pipelines              []Pipeline `yaml:"pipelines"`
-	Resources              []Resource `yaml:"resources"`
-	GitHubIps              []string
+	GlobalGitHubSecretName      string      `yaml:"globalGithubSecretName,omitempty"`
+	GlobalExtraParams           []ParamItem `yaml:"globalExtraParams,omitempty"`
+	GlobalServiceAccount        string      `yaml:"globalServiceAccount,omitempty"`
+	GlobalGithubPassword        string
+	GlobalSignatureAlgorithms   []string `yaml:"globalSignatureAlgorithms,omitempty"`
+	AllowedCommentAssociations  []string `yaml:"allowedCommentAssociations,omitempty"`
+	GlobalGitlabSecretName      string   `yaml:"globalGitlabSecretName,omitempty"`
+	GlobalGitlabToken           string
+	GlobalBitbucketSecretName   string `yaml:"globalBitbucketSecretName,omitempty"`
+	GlobalBitbucketPassword     string
+	GlobalGiteaSecretName       string `yaml:"globalGiteaSecretName,omitempty"`
+	GlobalGiteaPassword         string
+	GlobalCloudEventsSecretName string `yaml:"globalCloudEventsSecretName,omitempty"`
+	GlobalCloudEventsToken      string
+	AllowDryRun                 bool       `yaml:"allowDryRun,omitempty"`
+	Pipelines                   []Pipeline `yaml:"pipelines"`
+	Resources                   []Resource `yaml:"resources"`
+	GitHubIps                   []string
 }
 
 type Pipeline struct {
-	Name             string      `yaml:"name"`
-	ExtraParams      []ParamItem `yaml:"extraParams",ommitempty`
-	Workspaces       []Workspace `yaml:"workspaces",ommitempty`
-	Resources        []Resource  `yaml:"resources",ommitempty`
-	GithubSecretName string      `yaml:"githubSecretName",ommitempty`
-	GithubPassword   string
-	ServiceAccount   string     `yaml:"serviceAccount",ommitempty`
-	When             []WhenItem `yaml:"when",ommitempty`
+	Name                  string      `yaml:"name"`
+	ExtraParams           []ParamItem `yaml:"extraParams,omitempty"`
+	Workspaces            []Workspace `yaml:"workspaces,omitempty"`
+	Resources             []Resource  `yaml:"resources,omitempty"`
+	GithubSecretName      string      `yaml:"githubSecretName,omitempty"`
+	GithubPassword        string
+	ServiceAccount        string     `yaml:"serviceAccount,omitempty"`
+	When                  []WhenItem `yaml:"when,omitempty"`
+	SignatureAlgorithms   []string   `yaml:"signatureAlgorithms,omitempty"`
+	GitlabSecretName      string     `yaml:"gitlabSecretName,omitempty"`
+	GitlabToken           string
+	BitbucketSecretName   string `yaml:"bitbucketSecretName,omitempty"`
+	BitbucketPassword     string
+	GiteaSecretName       string `yaml:"giteaSecretName,omitempty"`
+	GiteaPassword         string
+	CloudEventsSecretName string `yaml:"cloudEventsSecretName,omitempty"`
+	CloudEventsToken      string
+	ResponseMode          string         `yaml:"responseMode,omitempty"`
+	SyncTimeout           string         `yaml:"syncTimeout,omitempty"`
+	RedeliveryPolicy      string         `yaml:"redeliveryPolicy,omitempty"`
+	Concurrency           *Concurrency   `yaml:"concurrency,omitempty"`
+	MaxConcurrent         int            `yaml:"maxConcurrent,omitempty"`
+	Notifications         []Notification `yaml:"notifications,omitempty"`
+
+	// Overwrites the global AllowedCommentAssociations
+	AllowedCommentAssociations []string `yaml:"allowedCommentAssociations,omitempty"`
+}
+
+// Notification is sent when a PipelineRun of the pipeline finishes in one of the States.
+// Message (and Subject in SMTP) are templates executed with the PipelineRun
+type Notification struct {
+	Type    string   `yaml:"type"`
+	States  []string `yaml:"states,omitempty"`
+	Message string   `yaml:"message,omitempty"`
+
+	// Webhook and Slack: url to post the notification, or a Secret with the key url (SecretName)
+	Url string `yaml:"url,omitempty"`
+
+	// SMTP
+	Smtp    *Smtp  `yaml:"smtp,omitempty"`
+	Subject string `yaml:"subject,omitempty"`
+
+	// Secret with the url (webhook and slack, key url) or the password of the SMTP server (key password)
+	SecretName string `yaml:"secretName,omitempty"`
+	Secret     string
+}
+
+type Smtp struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port,omitempty"`
+	Username string   `yaml:"username,omitempty"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// Concurrency configures what to do with the PipelineRuns still running when a new one with
+// the same key is created. Key is a template (e.g. "{{ .Repo }}-{{ .Ref }}")
+type Concurrency struct {
+	Key    string `yaml:"key,omitempty"`
+	Policy string `yaml:"policy,omitempty"`
 }
 
 type ParamItem struct {
-	Name  string `yaml:"name",ommitempty`
-	Value string `yaml:"value",ommitempty`
+	Name  string `yaml:"name,omitempty"`
+	Value string `yaml:"value,omitempty"`
 }
 
 type Workspace struct {
-	Name string `yaml:"name",ommitempty`
-	Type string `yaml:"type",ommitempty`
+	Name string `yaml:"name,omitempty"`
+	Type string `yaml:"type,omitempty"`
 	Data map[string]string
 }
 
 type Resource struct {
-	Name        string `yaml:"name",ommitempty`
-	ResourceRef string `yaml:"resourceRef",ommitempty`
+	Name        string `yaml:"name,omitempty"`
+	ResourceRef string `yaml:"resourceRef,omitempty"`
 }
 
 type WhenItem struct {
-	Kind   string      `yaml:"kind",ommitempty`
-	Keys   []string    `yaml:"keys",ommitempty`
-	Values []ValueItem `yaml:"values",ommitempty`
+	Kind   string      `yaml:"kind,omitempty"`
+	Keys   []string    `yaml:"keys,omitempty"`
+	Values []ValueItem `yaml:"values,omitempty"`
 }
 
 type ValueItem struct {
-	Operator string `yaml:"operator",ommitempty`
-	Data     string `yaml:"data",ommitempty`
+	Operator string `yaml:"operator,omitempty"`
+	Data     string `yaml:"data,omitempty"`
+}
+
+// WhenResult is the result of checking a when condition
+type WhenResult struct {
+	Kind    string   `yaml:"kind" json:"kind"`
+	Keys    []string `yaml:"keys" json:"keys"`
+	Matched bool     `yaml:"matched" json:"matched"`
+	Error   string   `yaml:"error,omitempty" json:"error,omitempty"`
 }
 
 func LoadConfig(haveToLoadGethubIps bool) error {
-	// Name of ConfigMap. The format is namethisapplication-config and
-	// must be stored in the same Namespace where the pod is running
-	//
-	// Example: custom-tekton-listener-config
-	nameConfigMap := fmt.Sprintf("%s-config", filepath.Base(os.Args[0]))
+	c := &config{}
+
+	err := loadConfig(c)
+
+	if err != nil {
+		return err
+	}
+
+	if haveToLoadGethubIps {
+		githubIps, err := loadGithubIps()
+
+		if err != nil {
+			return err
+		}
+
+		c.GitHubIps = githubIps
+	}
+
+	setConfiguration(c)
+
+	return nil
+}
+
+// ReloadConfig loads and parses the configuration again. The active configuration is
+// only replaced if the new one is valid
+func ReloadConfig() error {
+	_, err := reloadConfig()
+
+	return err
+}
+
+// reloadConfig returns the new configuration along with the error, if it was rejected. The
+// configuration rejected has the fields read before the error
+func reloadConfig() (*config, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	c := &config{}
+
+	err := loadConfig(c)
+
+	if err != nil {
+		return c, err
+	}
+
+	err = parseConfig(c)
+
+	if err != nil {
+		return c, err
+	}
+
+	// GitHub IPs are not loaded again
+	c.GitHubIps = getConfiguration().GitHubIps
+
+	setConfiguration(c)
+
+	return c, nil
+}
+
+// GetConfigMapName returns the name of the main ConfigMap. The format is
+// namethisapplication-config and must be stored in the same Namespace where the pod is running
+//
+// Example: custom-tekton-listener-config
+func GetConfigMapName() string {
+	return fmt.Sprintf("%s-config", filepath.Base(os.Args[0]))
+}
+
+func loadConfig(c *config) error {
+	nameConfigMap := GetConfigMapName()
 
 	configmap, err := k8s.GetConfigMap(nameConfigMap, os.Getenv("POD_NAMESPACE"))
 
@@ -116,46 +315,116 @@ func LoadConfig(haveToLoadGethubIps bool) error {
 	}
 
 	// Load ConfigMap
-	err = yaml.Unmarshal([]byte(config), &configuration)
+	err = decodeConfig([]byte(config), c)
 
 	if err != nil {
 		return err
 	}
+
 	// Check if webhook has global password
-	if len(configuration.GlobalGitHubSecretName) > 0 {
-		// Get Secret
-		secret, err := k8s.GetSecret(configuration.GlobalGitHubSecretName, os.Getenv("POD_NAMESPACE"))
+	if len(c.GlobalGitHubSecretName) > 0 {
+		c.GlobalGithubPassword, err = getSecretPassword(c.GlobalGitHubSecretName)
 
 		if err != nil {
 			return err
 		}
+	}
 
-		pass, ok := secret.Data["password"]
+	// Check if GitLab webhooks have global token
+	if len(c.GlobalGitlabSecretName) > 0 {
+		c.GlobalGitlabToken, err = getSecretPassword(c.GlobalGitlabSecretName)
 
-		if !ok {
-			return fmt.Errorf("field password not found in Secret %s", configuration.GlobalGitHubSecretName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Check if Bitbucket webhooks have global password
+	if len(c.GlobalBitbucketSecretName) > 0 {
+		c.GlobalBitbucketPassword, err = getSecretPassword(c.GlobalBitbucketSecretName)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// Check if Gitea webhooks have global password
+	if len(c.GlobalGiteaSecretName) > 0 {
+		c.GlobalGiteaPassword, err = getSecretPassword(c.GlobalGiteaSecretName)
+
+		if err != nil {
+			return err
 		}
+	}
 
-		configuration.GlobalGithubPassword = string(pass)
+	// Check if CloudEvents have global token
+	if len(c.GlobalCloudEventsSecretName) > 0 {
+		c.GlobalCloudEventsToken, err = getSecretPassword(c.GlobalCloudEventsSecretName)
+
+		if err != nil {
+			return err
+		}
 	}
 
 	// Check if pipelines have password
-	for i, p := range configuration.Pipelines {
+	for i, p := range c.Pipelines {
 		if len(p.GithubSecretName) > 0 {
-			// Get Secret
-			secret, err := k8s.GetSecret(p.GithubSecretName, os.Getenv("POD_NAMESPACE"))
+			c.Pipelines[i].GithubPassword, err = getSecretPassword(p.GithubSecretName)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(p.GitlabSecretName) > 0 {
+			c.Pipelines[i].GitlabToken, err = getSecretPassword(p.GitlabSecretName)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(p.BitbucketSecretName) > 0 {
+			c.Pipelines[i].BitbucketPassword, err = getSecretPassword(p.BitbucketSecretName)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(p.GiteaSecretName) > 0 {
+			c.Pipelines[i].GiteaPassword, err = getSecretPassword(p.GiteaSecretName)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(p.CloudEventsSecretName) > 0 {
+			c.Pipelines[i].CloudEventsToken, err = getSecretPassword(p.CloudEventsSecretName)
 
 			if err != nil {
 				return err
 			}
+		}
+
+		// Get the secrets of the notifications
+		for j, n := range p.Notifications {
+			if len(n.SecretName) == 0 {
+				continue
+			}
 
-			pass, ok := secret.Data["password"]
+			key := "url"
 
-			if !ok {
-				return fmt.Errorf("field password not found in Secret %s", configuration.GlobalGitHubSecretName)
+			if strings.EqualFold(n.Type, NotificationTypeSmtp) {
+				key = "password"
 			}
 
-			configuration.Pipelines[i].GithubPassword = string(pass)
+			c.Pipelines[i].Notifications[j].Secret, err = getSecretValue(n.SecretName, key)
+
+			if err != nil {
+				return err
+			}
 		}
 
 		// Check Worspaces
@@ -167,7 +436,7 @@ func LoadConfig(haveToLoadGethubIps bool) error {
 			}
 
 			// Get data from workspaces
-			err = getkWorkspacesData(i, p.Workspaces)
+			err = getkWorkspacesData(c, i, p.Workspaces, false)
 
 			if err != nil {
 				return err
@@ -175,77 +444,148 @@ func LoadConfig(haveToLoadGethubIps bool) error {
 		}
 	}
 
-	if haveToLoadGethubIps {
-		githubIps, err := loadGithubIps()
+	return nil
+}
 
-		if err != nil {
-			return err
+func ParseConfig() error {
+	return parseConfig(getConfiguration())
+}
+
+func parseConfig(c *config) error {
+	errs := validateConfig(c)
+
+	if len(errs) > 0 {
+		// Report all errors at once
+		messages := make([]string, len(errs))
+
+		for i := range errs {
+			messages[i] = errs[i].Error()
 		}
 
-		configuration.GitHubIps = githubIps
+		return errors.New(strings.Join(messages, "; "))
 	}
 
 	return nil
 }
 
-func ParseConfig() error {
-	if len(configuration.Pipelines) == 0 {
-		return errors.New("pipelines field is empty")
+// validateConfig checks the whole configuration and returns all errors found
+func validateConfig(c *config) []error {
+	v := &validator{}
+
+	if len(c.Pipelines) == 0 {
+		v.add("pipelines", "pipelines field is empty")
 	}
 
-	for _, p := range configuration.Pipelines {
+	// Check global signature algorithms
+	parseSignatureAlgorithms(v, "globalSignatureAlgorithms", c.GlobalSignatureAlgorithms)
+
+	// Check comment associations
+	parseCommentAssociations(v, "allowedCommentAssociations", c.AllowedCommentAssociations)
+
+	for i, e := range c.GlobalExtraParams {
+		if len(e.Name) == 0 {
+			v.add(fmt.Sprintf("globalExtraParams[%d].name", i), "found an empty name in extra params")
+		}
+	}
+
+	pipelineNames := make(map[string]int)
+
+	for i, p := range c.Pipelines {
+		path := fmt.Sprintf("pipelines[%d]", i)
+
 		// Check name pipeline
 		if len(p.Name) == 0 {
-			return fmt.Errorf("pipeline name is empty")
-		}
+			v.add(path+".name", "pipeline name is empty")
+		} else {
+			pipelineName := strings.ToLower(p.Name)
 
-		pipelineName := strings.ToLower(p.Name)
+			if !isValidPipeline(c, pipelineName) {
+				v.add(path+".name", "pipeline name unknown: %s (pipeline names must be lowercase)", p.Name)
+			}
 
-		if !isValidPipeline(pipelineName) {
-			return fmt.Errorf("pipeline name unknown: %s", pipelineName)
+			if j, ok := pipelineNames[pipelineName]; ok {
+				v.add(path+".name", "pipeline %s is already configured in pipelines[%d]", p.Name, j)
+			} else {
+				pipelineNames[pipelineName] = i
+			}
 		}
 
 		// Check extra params
 		//
 		// Note: We don't check the value because the value itself could be empty
-		if len(p.ExtraParams) > 0 {
-			for _, e := range p.ExtraParams {
-				if len(e.Name) == 0 {
-					return errors.New("found an empty name in extra params")
-				}
+		for j, e := range p.ExtraParams {
+			if len(e.Name) == 0 {
+				v.add(fmt.Sprintf("%s.extraParams[%d].name", path, j), "found an empty name in extra params")
 			}
 		}
 
 		// Check Resources
-		if len(p.Resources) > 0 {
-			for _, r := range p.Resources {
-				if len(r.Name) == 0 {
-					return errors.New("found an empty name in resources")
-				}
+		for j, r := range p.Resources {
+			if len(r.Name) == 0 {
+				v.add(fmt.Sprintf("%s.resources[%d].name", path, j), "found an empty name in resources")
+			}
 
-				if len(r.ResourceRef) == 0 {
-					return errors.New("found an empty resourceRef in resources")
-				}
+			if len(r.ResourceRef) == 0 {
+				v.add(fmt.Sprintf("%s.resources[%d].resourceRef", path, j), "found an empty resourceRef in resources")
 			}
 		}
 
+		// Check Workspaces
+		checkWorkspaces(v, path+".workspaces", p.Workspaces)
+
 		// Check when conditions
-		if len(p.When) > 0 {
-			err := parseWhen(p.When)
+		parseWhen(v, path+".when", p.When)
 
-			if err != nil {
-				return err
+		// Check signature algorithms
+		parseSignatureAlgorithms(v, path+".signatureAlgorithms", p.SignatureAlgorithms)
+
+		// Check response mode
+		if len(p.ResponseMode) > 0 && !sliceContains(strings.ToLower(p.ResponseMode), responseModes) {
+			v.add(path+".responseMode", "response mode (%s) unknown", p.ResponseMode)
+		}
+
+		if len(p.SyncTimeout) > 0 {
+			timeout, err := time.ParseDuration(p.SyncTimeout)
+
+			if err != nil || timeout <= 0 {
+				v.add(path+".syncTimeout", "invalid duration %s", p.SyncTimeout)
+			} else if timeout >= WriteTimeout {
+				v.add(path+".syncTimeout", "syncTimeout (%s) must be lower than the write timeout of the server (%s)",
+					p.SyncTimeout, WriteTimeout)
 			}
 		}
+
+		// Check concurrency
+		if p.Concurrency != nil {
+			parseConcurrency(v, path+".concurrency", p.Concurrency)
+		}
+
+		if p.MaxConcurrent < 0 {
+			v.add(path+".maxConcurrent", "maxConcurrent must be 0 (unlimited) or greater")
+		}
+
+		parseCommentAssociations(v, path+".allowedCommentAssociations", p.AllowedCommentAssociations)
+
+		// Check notifications
+		for j := range p.Notifications {
+			parseNotification(v, fmt.Sprintf("%s.notifications[%d]", path, j), &p.Notifications[j])
+		}
+
+		// Check redelivery policy
+		if len(p.RedeliveryPolicy) > 0 && !sliceContains(strings.ToLower(p.RedeliveryPolicy), redeliveryPolicies) {
+			v.add(path+".redeliveryPolicy", "redelivery policy (%s) unknown", p.RedeliveryPolicy)
+		}
 	}
 
-	return nil
+	return v.errs
 }
 
 func PipelineExists(pipelineName string) bool {
+	c := getConfiguration()
+
 	var exists bool
 
-	for _, item := range configuration.Pipelines {
+	for _, item := range c.Pipelines {
 		if item.Name == pipelineName {
 			exists = true
 			break
@@ -255,16 +595,138 @@ func PipelineExists(pipelineName string) bool {
 	return exists
 }
 
+// HasSecret checks if the webhooks of the provider for the pipeline are verified with a secret
+func HasSecret(provider string, pipeline *Pipeline) bool {
+	c := getConfiguration()
+
+	switch provider {
+	case "github":
+		return len(pipeline.GithubPassword) > 0 || len(c.GlobalGithubPassword) > 0
+	case "gitlab":
+		return len(pipeline.GitlabToken) > 0 || len(c.GlobalGitlabToken) > 0
+	case "bitbucket":
+		return len(pipeline.BitbucketPassword) > 0 || len(c.GlobalBitbucketPassword) > 0
+	case "gitea":
+		return len(pipeline.GiteaPassword) > 0 || len(c.GlobalGiteaPassword) > 0
+	case "cloudevents":
+		return len(pipeline.CloudEventsToken) > 0 || len(c.GlobalCloudEventsToken) > 0
+	}
+
+	return false
+}
+
 func GetGlobalGithubPassword() string {
-	return configuration.GlobalGithubPassword
+	return getConfiguration().GlobalGithubPassword
+}
+
+func GetGlobalGitlabToken() string {
+	return getConfiguration().GlobalGitlabToken
+}
+
+func GetGlobalBitbucketPassword() string {
+	return getConfiguration().GlobalBitbucketPassword
+}
+
+func GetGlobalGiteaPassword() string {
+	return getConfiguration().GlobalGiteaPassword
+}
+
+func GetGlobalCloudEventsToken() string {
+	return getConfiguration().GlobalCloudEventsToken
+}
+
+func IsDryRunAllowed() bool {
+	return getConfiguration().AllowDryRun
 }
 
 func GetGlobalServiceAccount() string {
-	return configuration.GlobalServiceAccount
+	return getConfiguration().GlobalServiceAccount
+}
+
+// GetSignatureAlgorithms returns the signature algorithms allowed for a pipeline
+// Note: The algorithms of the pipeline take precedence over the global ones
+func GetSignatureAlgorithms(pipeline *Pipeline) []string {
+	c := getConfiguration()
+
+	if pipeline != nil && len(pipeline.SignatureAlgorithms) > 0 {
+		return pipeline.SignatureAlgorithms
+	}
+
+	if len(c.GlobalSignatureAlgorithms) > 0 {
+		return c.GlobalSignatureAlgorithms
+	}
+
+	return defaultSignatureAlgorithms
+}
+
+// IsCommentAssociationAllowed checks if the commenters with association can run commands in the
+// pull requests of the pipeline
+func IsCommentAssociationAllowed(pipeline *Pipeline, association string) bool {
+	c := getConfiguration()
+
+	allowed := defaultCommentAssociations
+
+	if pipeline != nil && len(pipeline.AllowedCommentAssociations) > 0 {
+		allowed = pipeline.AllowedCommentAssociations
+	} else if len(c.AllowedCommentAssociations) > 0 {
+		allowed = c.AllowedCommentAssociations
+	}
+
+	for _, a := range allowed {
+		if strings.EqualFold(a, association) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsSync checks if the response to the webhook is sent after processing the request
+func (p *Pipeline) IsSync() bool {
+	return strings.EqualFold(p.ResponseMode, ResponseModeSync)
+}
+
+// GetPolicy returns what to do with the older PipelineRuns (cancel by default)
+func (c *Concurrency) GetPolicy() string {
+	if len(c.Policy) == 0 {
+		return ConcurrencyPolicyCancel
+	}
+
+	return strings.ToLower(c.Policy)
+}
+
+// AllowsRedelivery checks if a delivery received again launches another PipelineRun. By default
+// the redeliveries are skipped
+func (p *Pipeline) AllowsRedelivery() bool {
+	return strings.EqualFold(p.RedeliveryPolicy, RedeliveryPolicyAllow)
+}
+
+// GetSyncTimeout returns how long the request is processed before responding in sync mode
+func (p *Pipeline) GetSyncTimeout() time.Duration {
+	timeout, err := time.ParseDuration(p.SyncTimeout)
+
+	if err != nil || timeout <= 0 {
+		return defaultSyncTimeout
+	}
+
+	return timeout
+}
+
+// IsSignatureAlgorithmAllowed checks if algorithm is present in algorithms
+func IsSignatureAlgorithmAllowed(algorithm string, algorithms []string) bool {
+	for _, a := range algorithms {
+		if strings.EqualFold(a, algorithm) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func GetPipeline(pipelineName string) *Pipeline {
-	for _, item := range configuration.Pipelines {
+	c := getConfiguration()
+
+	for _, item := range c.Pipelines {
 		if item.Name == strings.ToLower(pipelineName) {
 			return &item
 		}
@@ -272,10 +734,23 @@ func GetPipeline(pipelineName string) *Pipeline {
 	return nil
 }
 
+// GetPipelines returns all the pipelines of the configuration
+func GetPipelines() []Pipeline {
+	c := getConfiguration()
+
+	pipelines := make([]Pipeline, len(c.Pipelines))
+
+	copy(pipelines, c.Pipelines)
+
+	return pipelines
+}
+
 func GetGlobalExtraParams() map[string]string {
+	c := getConfiguration()
+
 	extraParams := make(map[string]string)
 
-	for _, item := range configuration.GlobalExtraParams {
+	for _, item := range c.GlobalExtraParams {
 		extraParams[item.Name] = item.Value
 	}
 
@@ -283,39 +758,64 @@ func GetGlobalExtraParams() map[string]string {
 }
 
 func GetGithubIps() []string {
-	return configuration.GitHubIps
+	return getConfiguration().GitHubIps
 }
 
-func CheckWhenConditions(when []WhenItem, queryParams url.Values, r *http.Request, payload []byte) (bool, error) {
-	var totalMatches int = 0
+// EvaluateWhenConditions checks if all when conditions are fulfilled. All of them are checked and
+// the result of each one is returned (useful to know why a pipeline is not launched). attributes
+// are the CloudEvents attributes (type, source, subject, ...), it is empty for the rest of providers
+func EvaluateWhenConditions(when []WhenItem, queryParams url.Values, r *http.Request, payload []byte,
+	attributes map[string]string) ([]WhenResult, bool, error) {
+	var results []WhenResult
+	var firstErr error
+
+	pass := true
 
 	for _, whenItem := range when {
-		var match bool
-		var err error
+		match, err := checkWhenItem(whenItem, queryParams, r, payload, attributes)
 
-		switch strings.ToLower(whenItem.Kind) {
-		case whenKindPayload:
-			match, err = checkPayloadCondition(whenItem, payload)
-		case whenKindHeader:
-			match, err = checkHttpHeadersCondition(whenItem, r.Header)
-		case whenKindQuery:
-			match, err = checkQueryParamsCondition(whenItem, queryParams)
+		result := WhenResult{
+			Kind:    whenItem.Kind,
+			Keys:    whenItem.Keys,
+			Matched: match,
 		}
 
 		if err != nil {
-			return false, err
+			result.Error = err.Error()
+
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
 
-		if match {
-			totalMatches++
-		} else {
-			// Match not found. It is not necessary to check more because they
-			// have to be fulfilled all
-			break
+		if !match {
+			pass = false
 		}
+
+		results = append(results, result)
 	}
 
-	return totalMatches == len(when), nil
+	if firstErr != nil {
+		return results, false, firstErr
+	}
+
+	return results, pass, nil
+}
+
+func checkWhenItem(whenItem WhenItem, queryParams url.Values, r *http.Request, payload []byte,
+	attributes map[string]string) (bool, error) {
+	switch strings.ToLower(whenItem.Kind) {
+	case whenKindPayload:
+		return checkPayloadCondition(whenItem, payload)
+	case whenKindHeader:
+		return checkHttpHeadersCondition(whenItem, r.Header)
+	case whenKindQuery:
+		return checkQueryParamsCondition(whenItem, queryParams)
+	case whenKindCloudEvent:
+		return checkCloudEventCondition(whenItem, attributes)
+	}
+
+	return false, nil
 }
 
 func checkPayloadCondition(whenItem WhenItem, payload []byte) (bool, error) {
@@ -399,6 +899,33 @@ func checkQueryParamsCondition(whenItem WhenItem, queryParams url.Values) (bool,
 	return false, nil
 }
 
+func checkCloudEventCondition(whenItem WhenItem, attributes map[string]string) (bool, error) {
+	for _, k := range whenItem.Keys {
+		// Keys can be set with the prefix of the http headers in binary mode (e.g. ce-type)
+		key := strings.TrimPrefix(strings.ToLower(k), "ce-")
+
+		attrValue, ok := attributes[key]
+
+		if !ok {
+			continue
+		}
+
+		for _, whenValue := range whenItem.Values {
+			match, err := isDataMatches(whenValue, attrValue)
+
+			if err != nil {
+				return false, err
+			}
+
+			if match {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
 // sliceContains checks if a string is present in a slice
 func sliceContains(str string, s []string) bool {
 	for _, v := range s {
@@ -410,10 +937,10 @@ func sliceContains(str string, s []string) bool {
 	return false
 }
 
-func isValidPipeline(pipelineName string) bool {
+func isValidPipeline(c *config, pipelineName string) bool {
 	var valid bool
 
-	for _, item := range configuration.Pipelines {
+	for _, item := range c.Pipelines {
 		if item.Name == pipelineName {
 			valid = true
 			break
@@ -423,27 +950,73 @@ func isValidPipeline(pipelineName string) bool {
 	return valid
 }
 
+func getConfiguration() *config {
+	configurationMutex.RLock()
+	defer configurationMutex.RUnlock()
+
+	return configuration
+}
+
+func setConfiguration(c *config) {
+	configurationMutex.Lock()
+	defer configurationMutex.Unlock()
+
+	configuration = c
+}
+
+// getSecretPassword returns the field password from a Secret
+func getSecretPassword(secretName string) (string, error) {
+	return getSecretValue(secretName, "password")
+}
+
+func getSecretValue(secretName string, key string) (string, error) {
+	secret, err := k8s.GetSecret(secretName, os.Getenv("POD_NAMESPACE"))
+
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secret.Data[key]
+
+	if !ok {
+		return "", fmt.Errorf("field %s not found in Secret %s", key, secretName)
+	}
+
+	return string(value), nil
+}
+
 func checkWorkspacesConfig(workspaces []Workspace) error {
-	for _, w := range workspaces {
+	v := &validator{}
+
+	checkWorkspaces(v, "workspaces", workspaces)
+
+	if len(v.errs) > 0 {
+		return v.errs[0]
+	}
+
+	return nil
+}
+
+func checkWorkspaces(v *validator, path string, workspaces []Workspace) {
+	for i, w := range workspaces {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
 
 		if len(w.Name) == 0 {
-			return errors.New("found an empty workspace name")
+			v.add(itemPath+".name", "found an empty workspace name")
 		}
 
 		if len(w.Type) == 0 {
-			return errors.New("found an empty workspace type")
-		}
-
-		// Check type
-		if !sliceContains(w.Type, workspacesTypes) {
-			return errors.New("workspace type unknown")
+			v.add(itemPath+".type", "found an empty workspace type")
+		} else if !sliceContains(w.Type, workspacesTypes) {
+			// Check type
+			v.add(itemPath+".type", "workspace type unknown: %s", w.Type)
 		}
 	}
-
-	return nil
 }
 
-func getkWorkspacesData(pipelineIdx int, workspaces []Workspace) error {
+// getkWorkspacesData sets the template of the workspaces. If offline is true, the ConfigMaps
+// are not read from the cluster
+func getkWorkspacesData(c *config, pipelineIdx int, workspaces []Workspace, offline bool) error {
 	for i, w := range workspaces {
 		switch strings.ToLower(w.Type) {
 		case strings.ToLower(EmptyDirType):
@@ -452,8 +1025,19 @@ func getkWorkspacesData(pipelineIdx int, workspaces []Workspace) error {
 			emptyDirData["template"] = fmt.Sprintf(`- name: %s
   emptyDir: {}`, w.Name)
 
-			configuration.Pipelines[pipelineIdx].Workspaces[i].Data = emptyDirData
+			c.Pipelines[pipelineIdx].Workspaces[i].Data = emptyDirData
 		case strings.ToLower(PersistentVolumeClaimType), strings.ToLower(VolumeClaimTemplateType):
+			if offline {
+				offlineData := make(map[string]string)
+
+				offlineData["template"] = fmt.Sprintf("# %s %s: template from ConfigMap %s not loaded offline",
+					w.Type, w.Name, w.Name)
+
+				c.Pipelines[pipelineIdx].Workspaces[i].Data = offlineData
+
+				continue
+			}
+
 			// Get ConfigMap
 			configmap, err := k8s.GetConfigMap(w.Name, os.Getenv("POD_NAMESPACE"))
 
@@ -461,7 +1045,7 @@ func getkWorkspacesData(pipelineIdx int, workspaces []Workspace) error {
 				return err
 			}
 
-			configuration.Pipelines[pipelineIdx].Workspaces[i].Data = configmap.Data
+			c.Pipelines[pipelineIdx].Workspaces[i].Data = configmap.Data
 		case strings.ToLower(ConfigmapType):
 			configMapData := make(map[string]string)
 
@@ -469,7 +1053,7 @@ func getkWorkspacesData(pipelineIdx int, workspaces []Workspace) error {
   configmap: 
     name: %s`, w.Name, w.Name)
 
-			configuration.Pipelines[pipelineIdx].Workspaces[i].Data = configMapData
+			c.Pipelines[pipelineIdx].Workspaces[i].Data = configMapData
 		case strings.ToLower(SecretType):
 			secretData := make(map[string]string)
 
@@ -477,7 +1061,7 @@ func getkWorkspacesData(pipelineIdx int, workspaces []Workspace) error {
   secret: 
     name: %s`, w.Name, w.Name)
 
-			configuration.Pipelines[pipelineIdx].Workspaces[i].Data = secretData
+			c.Pipelines[pipelineIdx].Workspaces[i].Data = secretData
 		}
 	}
 
@@ -544,50 +1128,62 @@ func loadGithubIps() ([]string, error) {
 	return ips, nil
 }
 
-func parseWhen(when []WhenItem) error {
-	for _, whenItem := range when {
+func parseWhen(v *validator, path string, when []WhenItem) {
+	for i, whenItem := range when {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+
 		if len(whenItem.Kind) == 0 {
-			return errors.New("kind field is empty in when clause")
+			v.add(itemPath+".kind", "kind field is empty in when clause")
+		} else if !sliceContains(strings.ToLower(whenItem.Kind), whenKinds) {
+			// Check kind
+			v.add(itemPath+".kind", "kind field (%s) unknown in when clause", whenItem.Kind)
 		}
 
 		if len(whenItem.Keys) == 0 {
-			return errors.New("keys field is empty in when clause")
+			v.add(itemPath+".keys", "keys field is empty in when clause")
 		}
 
 		if len(whenItem.Values) == 0 {
-			return errors.New("values field is empty in when clause")
-		}
-
-		// Check kind
-		if !sliceContains(strings.ToLower(whenItem.Kind), whenKinds) {
-			return fmt.Errorf("kind field (%s) unknown in when clause", whenItem.Kind)
+			v.add(itemPath+".values", "values field is empty in when clause")
 		}
 
 		// Check keys
-		for i := range whenItem.Keys {
-			if len(whenItem.Keys[i]) == 0 {
-				return fmt.Errorf("found an empty key in when clause in kind %s", whenItem.Kind)
+		for j := range whenItem.Keys {
+			if len(whenItem.Keys[j]) == 0 {
+				v.add(fmt.Sprintf("%s.keys[%d]", itemPath, j), "found an empty key in when clause in kind %s", whenItem.Kind)
 			}
 		}
 
 		// Check values
-		for _, v := range whenItem.Values {
-			if len(v.Operator) == 0 {
-				return fmt.Errorf("found an empty operator in when clause in kind %s", whenItem.Kind)
-			} else {
-				// Check if opeerator is correct
-				if !sliceContains(strings.ToLower(v.Operator), valuesOperators) {
-					return fmt.Errorf("found a unknown operator in when clause in kind %s", whenItem.Kind)
-				}
+		for j, value := range whenItem.Values {
+			valuePath := fmt.Sprintf("%s.values[%d]", itemPath, j)
+			operator := strings.ToLower(value.Operator)
+
+			if len(value.Operator) == 0 {
+				v.add(valuePath+".operator", "found an empty operator in when clause in kind %s", whenItem.Kind)
+			} else if !sliceContains(operator, valuesOperators) {
+				// Check if operator is correct
+				v.add(valuePath+".operator", "found a unknown operator (%s) in when clause in kind %s", value.Operator, whenItem.Kind)
 			}
 
-			if len(v.Data) == 0 {
-				return fmt.Errorf("found an empty data in when clause in kind %s", whenItem.Kind)
+			if len(value.Data) == 0 {
+				v.add(valuePath+".data", "found an empty data in when clause in kind %s", whenItem.Kind)
+			} else if operator == valueOperatorContains || operator == valueOperatorNotContains {
+				// contains and notcontains are regular expressions
+				if _, err := regexp.Compile(value.Data); err != nil {
+					v.add(valuePath+".data", "invalid regular expression: %s", err.Error())
+				}
 			}
 		}
 	}
+}
 
-	return nil
+func parseSignatureAlgorithms(v *validator, path string, algorithms []string) {
+	for i, a := range algorithms {
+		if !sliceContains(strings.ToLower(a), signatureAlgorithms) {
+			v.add(fmt.Sprintf("%s[%d]", path, i), "signature algorithm (%s) unknown", a)
+		}
+	}
 }
 
 func isDataMatches(valueItem ValueItem, value string) (bool, error) {
@@ -624,3 +1220,115 @@ func isDataMatches(valueItem ValueItem, value string) (bool, error) {
 
 	return false, nil
 }
+
+func parseConcurrency(v *validator, path string, c *Concurrency) {
+	if len(c.Key) == 0 {
+		v.add(path+".key", "concurrency key is empty")
+	} else {
+		_, err := template.New("key").Funcs(sprig.TxtFuncMap()).Parse(c.Key)
+
+		if err != nil {
+			v.add(path+".key", "invalid template: %s", err.Error())
+		}
+	}
+
+	if len(c.Policy) > 0 && !sliceContains(strings.ToLower(c.Policy), concurrencyPolicies) {
+		v.add(path+".policy", "concurrency policy (%s) unknown", c.Policy)
+	}
+}
+
+func parseNotification(v *validator, path string, n *Notification) {
+	notificationType := strings.ToLower(n.Type)
+
+	if !sliceContains(notificationType, notificationTypes) {
+		v.add(path+".type", "notification type (%s) unknown", n.Type)
+	}
+
+	for i, state := range n.States {
+		if !sliceContains(strings.ToLower(state), notifyStates) {
+			v.add(fmt.Sprintf("%s.states[%d]", path, i), "state (%s) unknown", state)
+		}
+	}
+
+	_, err := template.New("message").Funcs(sprig.TxtFuncMap()).Parse(n.Message)
+
+	if err != nil {
+		v.add(path+".message", "invalid template: %s", err.Error())
+	}
+
+	_, err = template.New("subject").Funcs(sprig.TxtFuncMap()).Parse(n.Subject)
+
+	if err != nil {
+		v.add(path+".subject", "invalid template: %s", err.Error())
+	}
+
+	switch notificationType {
+	case NotificationTypeWebhook, NotificationTypeSlack:
+		if len(n.Url) == 0 && len(n.SecretName) == 0 {
+			v.add(path+".url", "url or secretName is required")
+		}
+
+		if len(n.Url) > 0 {
+			u, err := url.Parse(n.Url)
+
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				v.add(path+".url", "invalid url %s", n.Url)
+			}
+		}
+	case NotificationTypeSmtp:
+		if n.Smtp == nil {
+			v.add(path+".smtp", "smtp is required")
+			return
+		}
+
+		if len(n.Smtp.Host) == 0 {
+			v.add(path+".smtp.host", "smtp host is empty")
+		}
+
+		if len(n.Smtp.From) == 0 {
+			v.add(path+".smtp.from", "smtp from is empty")
+		}
+
+		if len(n.Smtp.To) == 0 {
+			v.add(path+".smtp.to", "smtp to is empty")
+		}
+
+		if n.Smtp.Port < 0 {
+			v.add(path+".smtp.port", "invalid port %d", n.Smtp.Port)
+		}
+	}
+}
+
+// Notifies checks if the notification has to be sent when a PipelineRun finishes in state
+func (n *Notification) Notifies(state string) bool {
+	states := n.States
+
+	if len(states) == 0 {
+		states = defaultNotifyStates
+	}
+
+	for _, s := range states {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetUrl returns the url of the webhook, the one of the Secret takes precedence
+func (n *Notification) GetUrl() string {
+	if len(n.Secret) > 0 {
+		return n.Secret
+	}
+
+	return n.Url
+}
+
+func parseCommentAssociations(v *validator, path string, associations []string) {
+	for i, a := range associations {
+		if !sliceContains(strings.ToUpper(a), commentAssociations) {
+			v.add(fmt.Sprintf("%s[%d]", path, i), "comment association (%s) unknown", a)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluateWhenConditions(t *testing.T) {
+	payload := []byte(`{"ref": "refs/heads/main", "action": "", "repository": {"full_name": "owner/repo"}}`)
+
+	query := url.Values{"pipeline": {"build"}, "Env": {"dev", "prod"}}
+
+	attributes := map[string]string{"type": "com.example.build", "source": "/ci"}
+
+	equal := func(data string) ValueItem { return ValueItem{Operator: "equal", Data: data} }
+
+	tests := []struct {
+		name    string
+		when    []WhenItem
+		matched []bool
+		pass    bool
+		wantErr bool
+	}{
+		{
+			name: "no conditions",
+			pass: true,
+		},
+		{
+			name:    "payload equal",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"ref"}, Values: []ValueItem{equal("refs/heads/main")}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name:    "payload nested key",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"repository.full_name"}, Values: []ValueItem{equal("owner/repo")}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name: "payload any key and any value",
+			when: []WhenItem{{Kind: "payload", Keys: []string{"missing", "ref"},
+				Values: []ValueItem{equal("refs/heads/dev"), equal("refs/heads/main")}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name:    "payload empty value",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"action"}, Values: []ValueItem{{Operator: "notequal", Data: "x"}}}},
+			matched: []bool{false},
+		},
+		{
+			name:    "payload key not found",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"missing"}, Values: []ValueItem{{Operator: "notequal", Data: "x"}}}},
+			matched: []bool{false},
+		},
+		{
+			name:    "payload notequal",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"ref"}, Values: []ValueItem{{Operator: "notequal", Data: "refs/heads/main"}}}},
+			matched: []bool{false},
+		},
+		{
+			name:    "payload contains",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"ref"}, Values: []ValueItem{{Operator: "Contains", Data: "^refs/heads/"}}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name:    "payload notcontains",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"ref"}, Values: []ValueItem{{Operator: "notcontains", Data: "^refs/tags/"}}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name:    "invalid regular expression",
+			when:    []WhenItem{{Kind: "payload", Keys: []string{"ref"}, Values: []ValueItem{{Operator: "contains", Data: "("}}}},
+			matched: []bool{false},
+			wantErr: true,
+		},
+		{
+			name:    "header case insensitive",
+			when:    []WhenItem{{Kind: "header", Keys: []string{"x-github-event"}, Values: []ValueItem{equal("push")}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name:    "query first value",
+			when:    []WhenItem{{Kind: "query", Keys: []string{"env"}, Values: []ValueItem{equal("prod")}}},
+			matched: []bool{false},
+		},
+		{
+			name:    "cloudevent with header prefix",
+			when:    []WhenItem{{Kind: "cloudevent", Keys: []string{"ce-type"}, Values: []ValueItem{equal("com.example.build")}}},
+			matched: []bool{true},
+			pass:    true,
+		},
+		{
+			name: "all conditions are evaluated",
+			when: []WhenItem{
+				{Kind: "query", Keys: []string{"env"}, Values: []ValueItem{equal("prod")}},
+				{Kind: "header", Keys: []string{"X-GitHub-Event"}, Values: []ValueItem{equal("push")}},
+			},
+			matched: []bool{false, true},
+		},
+		{
+			name: "the first error is returned",
+			when: []WhenItem{
+				{Kind: "payload", Keys: []string{"ref"}, Values: []ValueItem{{Operator: "contains", Data: "["}}},
+				{Kind: "cloudevent", Keys: []string{"source"}, Values: []ValueItem{equal("/ci")}},
+			},
+			matched: []bool{false, true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/github", nil)
+			r.Header.Set("X-GitHub-Event", "push")
+
+			results, pass, err := EvaluateWhenConditions(tt.when, query, r, payload, attributes)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvaluateWhenConditions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if pass != tt.pass {
+				t.Errorf("EvaluateWhenConditions() pass = %v, want %v", pass, tt.pass)
+			}
+
+			var matched []bool
+
+			for i, result := range results {
+				matched = append(matched, result.Matched)
+
+				if !reflect.DeepEqual(result.Keys, tt.when[i].Keys) || result.Kind != tt.when[i].Kind {
+					t.Errorf("result %d is for %s %v, want %s %v", i, result.Kind, result.Keys, tt.when[i].Kind, tt.when[i].Keys)
+				}
+			}
+
+			if !reflect.DeepEqual(matched, tt.matched) {
+				t.Errorf("EvaluateWhenConditions() matched = %v, want %v", matched, tt.matched)
+			}
+		})
+	}
+}
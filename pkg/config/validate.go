@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ValidationError is an error found in the configuration along with its YAML path
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// validator collects the errors found in the configuration
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(path string, format string, a ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+type configMapManifest struct {
+	Kind string            `yaml:"kind"`
+	Data map[string]string `yaml:"data"`
+}
+
+// ValidateConfig checks a configuration without a cluster. data can be the ConfigMap manifest
+// or the raw configuration (the content of the key config). It returns all errors found
+//
+// Note: The Secrets and the ConfigMaps of the workspaces are not checked
+func ValidateConfig(data []byte) []error {
+	data, pathPrefix, err := extractConfig(data)
+
+	if err != nil {
+		return []error{&ValidationError{Path: "data", Message: err.Error()}}
+	}
+
+	c := &config{}
+
+	var errs []error
+
+	err = decodeConfig(data, c)
+
+	if err != nil {
+		path := strings.TrimSuffix(pathPrefix, ".")
+
+		if len(path) == 0 {
+			path = "config"
+		}
+
+		typeErr, ok := err.(*yaml.TypeError)
+
+		if !ok {
+			// Malformed YAML, nothing else can be checked
+			return []error{&ValidationError{Path: path, Message: err.Error()}}
+		}
+
+		// The rest of the configuration is decoded anyway
+		for _, e := range typeErr.Errors {
+			errs = append(errs, &ValidationError{Path: path, Message: e})
+		}
+	}
+
+	for _, e := range validateConfig(c) {
+		if vErr, ok := e.(*ValidationError); ok {
+			vErr.Path = pathPrefix + vErr.Path
+		}
+
+		errs = append(errs, e)
+	}
+
+	return errs
+}
+
+// LoadConfigFromData loads the configuration without a cluster (see ValidateConfig for the
+// format of data). It is used to render PipelineRuns offline
+//
+// Note: The Secrets are not read, so the signatures are not checked, and the workspaces that
+// need a ConfigMap (volumeClaimTemplate and persistentVolumeClaim) are rendered as a comment
+func LoadConfigFromData(data []byte) error {
+	data, _, err := extractConfig(data)
+
+	if err != nil {
+		return err
+	}
+
+	c := &config{}
+
+	err = decodeConfig(data, c)
+
+	if err != nil {
+		return err
+	}
+
+	err = parseConfig(c)
+
+	if err != nil {
+		return err
+	}
+
+	for i, p := range c.Pipelines {
+		err = getkWorkspacesData(c, i, p.Workspaces, true)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	setConfiguration(c)
+
+	return nil
+}
+
+// decodeConfig decodes the raw configuration. Unknown fields are reported as errors (e.g. a typo
+// in the name of a field)
+//
+// Note: It is used both at runtime and by ValidateConfig, so the configurations that pass the
+// validation are loaded
+func decodeConfig(data []byte, c *config) error {
+	return yaml.UnmarshalStrict(data, c)
+}
+
+// extractConfig returns the raw configuration if data is a ConfigMap manifest, along with the
+// YAML path of the configuration
+func extractConfig(data []byte) ([]byte, string, error) {
+	var manifest configMapManifest
+
+	// Check if data is a ConfigMap
+	err := yaml.Unmarshal(data, &manifest)
+
+	if err != nil || manifest.Kind != "ConfigMap" {
+		return data, "", nil
+	}
+
+	rawConfig, ok := manifest.Data["config"]
+
+	if !ok {
+		return nil, "", errors.New("key config not found in ConfigMap")
+	}
+
+	return []byte(rawConfig), "data.config.", nil
+}
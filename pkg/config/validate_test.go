@@ -0,0 +1,158 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		paths  []string // Paths of the errors, in order
+	}{
+		{
+			name: "valid",
+			config: `
+pipelines:
+- name: build
+  when:
+  - kind: payload
+    keys: [ref]
+    values:
+    - operator: equal
+      data: refs/heads/main
+`,
+		},
+		{
+			name: "valid ConfigMap manifest",
+			config: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: custom-tekton-listener
+data:
+  config: |
+    pipelines:
+    - name: build
+`,
+		},
+		{
+			name:   "without pipelines",
+			config: `globalServiceAccount: pipeline`,
+			paths:  []string{"pipelines"},
+		},
+		{
+			name: "unknown field",
+			config: `
+pipelines:
+- name: build
+  pipelineRun: build.yaml
+`,
+			paths: []string{"config"},
+		},
+		{
+			name: "errors in the ConfigMap are prefixed",
+			config: `
+kind: ConfigMap
+data:
+  config: |
+    pipelines:
+    - name: Build
+`,
+			paths: []string{"data.config.pipelines[0].name"},
+		},
+		{
+			name: "duplicated pipeline",
+			config: `
+pipelines:
+- name: build
+- name: build
+`,
+			paths: []string{"pipelines[1].name"},
+		},
+		{
+			name: "when conditions",
+			config: `
+pipelines:
+- name: build
+  when:
+  - kind: body
+    keys: [ref]
+    values:
+    - operator: equal
+      data: main
+  - kind: payload
+    keys: [ref]
+    values:
+    - operator: contains
+      data: "("
+`,
+			paths: []string{"pipelines[0].when[0].kind", "pipelines[0].when[1].values[0].data"},
+		},
+		{
+			name: "response mode",
+			config: `
+pipelines:
+- name: build
+  responseMode: sync
+  syncTimeout: 5s
+- name: deploy
+  responseMode: later
+- name: test
+  syncTimeout: soon
+`,
+			paths: []string{"pipelines[1].responseMode", "pipelines[2].syncTimeout"},
+		},
+		{
+			name: "sync timeout",
+			config: `
+pipelines:
+- name: build
+  responseMode: sync
+  syncTimeout: 14s
+- name: deploy
+  responseMode: sync
+  syncTimeout: 15s
+`,
+			paths: []string{"pipelines[1].syncTimeout"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+
+			for _, err := range ValidateConfig([]byte(tt.config)) {
+				vErr, ok := err.(*ValidationError)
+
+				if !ok {
+					t.Fatalf("ValidateConfig() returned %T: %v", err, err)
+				}
+
+				paths = append(paths, vErr.Path)
+			}
+
+			if !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("ValidateConfig() errors in %v, want %v", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromDataUnknownField(t *testing.T) {
+	// The configuration rejected by ValidateConfig is not loaded either
+	data := []byte(`
+pipelines:
+- name: build
+  maxconcurrent: 2
+`)
+
+	if errs := ValidateConfig(data); len(errs) == 0 {
+		t.Error("ValidateConfig() did not report the unknown field")
+	}
+
+	if err := LoadConfigFromData(data); err == nil {
+		t.Error("LoadConfigFromData() loaded a configuration with an unknown field")
+	}
+}
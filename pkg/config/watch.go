@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+)
+
+// object is a ConfigMap or a Secret referenced by the configuration
+type object struct {
+	Kind string
+	Name string
+}
+
+// configWatcher keeps a watch for each object referenced by the active configuration and, if
+// the last reload was rejected, by the configuration rejected (e.g. a Secret that does not
+// exist yet)
+type configWatcher struct {
+	namespace string
+	stopCh    <-chan struct{}
+	onReload  func(kind string, name string, err error)
+
+	mutex     sync.Mutex
+	watches   map[object]chan struct{}
+	lastError string // Error of the last reload, the Event is only created when it changes
+}
+
+// WatchConfig reloads the configuration when the main ConfigMap, the Secrets or the ConfigMaps
+// of the workspaces change. onReload is called after each reload with the result. If the new
+// configuration is not valid, the active configuration is kept and a warning Event is created
+// in the main ConfigMap
+//
+// Note: Only the objects referenced are watched, so the ServiceAccount only needs permission
+// to list and watch them by name
+func WatchConfig(stopCh <-chan struct{}, onReload func(kind string, name string, err error)) error {
+	w := &configWatcher{
+		namespace: os.Getenv("POD_NAMESPACE"),
+		stopCh:    stopCh,
+		onReload:  onReload,
+		watches:   make(map[object]chan struct{}),
+	}
+
+	go func() {
+		<-stopCh
+
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		for o, ch := range w.watches {
+			close(ch)
+			delete(w.watches, o)
+		}
+	}()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.update(referencedObjects(getConfiguration()))
+}
+
+// reload loads the configuration after a change of the object and updates the watches
+func (w *configWatcher) reload(o object) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	c, err := reloadConfig()
+
+	objects := referencedObjects(getConfiguration())
+
+	if err != nil {
+		for rejected := range referencedObjects(c) {
+			objects[rejected] = true
+		}
+
+		// Note: The Event is not created again while the error does not change, e.g. when the
+		//       rest of objects change
+		if err.Error() != w.lastError {
+			message := fmt.Sprintf("configuration rejected after changing %s %s: %s", o.Kind, o.Name, err.Error())
+
+			evErr := k8s.CreateEvent("ConfigMap", GetConfigMapName(), w.namespace, corev1.EventTypeWarning,
+				"ConfigRejected", message)
+
+			w.lastError = err.Error()
+
+			if evErr != nil {
+				err = fmt.Errorf("%s (unable to create event: %s)", err.Error(), evErr.Error())
+			}
+		}
+	} else {
+		w.lastError = ""
+	}
+
+	// Note: The watches only fail to start if stopCh is closed
+	w.update(objects)
+
+	w.onReload(o.Kind, o.Name, err)
+}
+
+// update starts the watches of the objects not watched yet and stops the watches of the objects
+// no longer referenced. The mutex must be held
+func (w *configWatcher) update(objects map[object]bool) error {
+	select {
+	case <-w.stopCh:
+		return nil
+	default:
+	}
+
+	for o, ch := range w.watches {
+		if !objects[o] {
+			close(ch)
+			delete(w.watches, o)
+		}
+	}
+
+	var errs []string
+
+	for o := range objects {
+		if _, ok := w.watches[o]; ok {
+			continue
+		}
+
+		ch := make(chan struct{})
+
+		o := o
+
+		err := k8s.WatchConfigMapOrSecret(o.Kind, o.Name, w.namespace, ch, func() {
+			// Note: The reload is done in another goroutine because it may start other watches
+			go w.reload(o)
+		})
+
+		if err != nil {
+			close(ch)
+			errs = append(errs, err.Error())
+
+			continue
+		}
+
+		w.watches[o] = ch
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to watch configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// referencedObjects returns the objects used by the configuration, the main ConfigMap is always
+// included
+func referencedObjects(c *config) map[object]bool {
+	objects := map[object]bool{{Kind: "ConfigMap", Name: GetConfigMapName()}: true}
+
+	for _, p := range c.Pipelines {
+		for _, w := range p.Workspaces {
+			// Only these types read the ConfigMap, the rest are mounted by Tekton
+			if strings.EqualFold(w.Type, PersistentVolumeClaimType) || strings.EqualFold(w.Type, VolumeClaimTemplateType) {
+				objects[object{Kind: "ConfigMap", Name: w.Name}] = true
+			}
+		}
+	}
+
+	secrets := []string{c.GlobalGitHubSecretName, c.GlobalGitlabSecretName, c.GlobalBitbucketSecretName,
+		c.GlobalGiteaSecretName, c.GlobalCloudEventsSecretName}
+
+	for _, p := range c.Pipelines {
+		secrets = append(secrets, p.GithubSecretName, p.GitlabSecretName, p.BitbucketSecretName,
+			p.GiteaSecretName, p.CloudEventsSecretName)
+
+		for _, n := range p.Notifications {
+			secrets = append(secrets, n.SecretName)
+		}
+	}
+
+	for _, name := range secrets {
+		if len(name) > 0 {
+			objects[object{Kind: "Secret", Name: name}] = true
+		}
+	}
+
+	return objects
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestReferencedObjects(t *testing.T) {
+	c := &config{}
+
+	err := yaml.Unmarshal([]byte(`
+globalGithubSecretName: github-global
+pipelines:
+- name: build
+  gitlabSecretName: gitlab-build
+  workspaces:
+  - name: build-pvc
+    type: persistentVolumeClaim
+  - name: build-config
+    type: configmap
+  notifications:
+  - type: slack
+    secretName: slack-build
+- name: deploy
+  githubSecretName: github-global
+`), c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[object]bool{
+		{Kind: "ConfigMap", Name: GetConfigMapName()}: true,
+		{Kind: "ConfigMap", Name: "build-pvc"}:        true,
+		{Kind: "Secret", Name: "github-global"}:       true,
+		{Kind: "Secret", Name: "gitlab-build"}:        true,
+		{Kind: "Secret", Name: "slack-build"}:         true,
+	}
+
+	if got := referencedObjects(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("referencedObjects() = %v, want %v", got, want)
+	}
+
+	// The main ConfigMap is watched even if the configuration cannot be read
+	want = map[object]bool{{Kind: "ConfigMap", Name: GetConfigMapName()}: true}
+
+	if got := referencedObjects(&config{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("referencedObjects() = %v, want %v", got, want)
+	}
+}
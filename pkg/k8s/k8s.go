@@ -4,20 +4,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tracing"
 )
 
 var (
@@ -25,12 +35,24 @@ var (
 	dynClient dynamic.Interface
 )
 
-func init() {
+// Init creates the kubernetes clients. It must be called before using the rest of functions
+//
+// Note: The clients are not created in init() because some subcommands (e.g. validate) run
+// without a cluster
+func Init() error {
 	err := createK8sClients()
 
 	if err != nil {
-		log.Fatal(fmt.Sprintf("unable to create kubernetes clients: %s", err.Error()))
+		return fmt.Errorf("unable to create kubernetes clients: %s", err.Error())
 	}
+
+	return nil
+}
+
+// SetDynamicClient replaces the dynamic client used to manage the objects (e.g. with a fake
+// client in the tests of other packages)
+func SetDynamicClient(client dynamic.Interface) {
+	dynClient = client
 }
 
 func GetConfigMap(nameConfigMap string, namespace string) (*corev1.ConfigMap, error) {
@@ -57,6 +79,139 @@ func GetSecret(secretName string, namespace string) (*corev1.Secret, error) {
 	return secret, nil
 }
 
+// WatchConfigMapOrSecret calls handler when the ConfigMap or the Secret (kind) name of namespace
+// is added, updated or deleted, until stopCh is closed. Only this object is listed and watched
+// (field selector metadata.name), so the permissions can be restricted with resourceNames
+//
+// Note: The object that exists when the watch starts is not notified
+func WatchConfigMapOrSecret(kind string, name string, namespace string, stopCh <-chan struct{}, handler func()) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	var informer cache.SharedIndexInformer
+
+	switch kind {
+	case "ConfigMap":
+		informer = factory.Core().V1().ConfigMaps().Informer()
+	case "Secret":
+		informer = factory.Core().V1().Secrets().Informer()
+	default:
+		return fmt.Errorf("unable to watch objects of kind %s", kind)
+	}
+
+	var synced int32
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			// Ignore the initial list
+			if atomic.LoadInt32(&synced) == 1 {
+				handler()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldO, ok1 := oldObj.(metav1.Object)
+			newO, ok2 := newObj.(metav1.Object)
+
+			if !ok1 || !ok2 || oldO.GetResourceVersion() == newO.GetResourceVersion() {
+				return
+			}
+
+			handler()
+		},
+		DeleteFunc: func(obj interface{}) {
+			handler()
+		},
+	})
+
+	factory.Start(stopCh)
+
+	for _, ok := range factory.WaitForCacheSync(stopCh) {
+		if !ok {
+			return fmt.Errorf("unable to sync informer for %s %s", kind, name)
+		}
+	}
+
+	atomic.StoreInt32(&synced, 1)
+
+	return nil
+}
+
+// WatchObjects calls handler with the objects of namespace that match labelSelector when they are
+// added or updated, until stopCh is closed. The objects that exist when the watch starts are
+// notified too
+func WatchObjects(k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string, labelSelector string,
+	stopCh <-chan struct{}, handler func(obj *unstructured.Unstructured)) error {
+	resource := schema.GroupVersionResource{Group: k8sApiGroup, Version: k8sApiVersion,
+		Resource: strings.ToLower(fmt.Sprintf("%ss", k8sKind))}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, 0, namespace, func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+	})
+
+	informer := factory.ForResource(resource).Informer()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if o, ok := obj.(*unstructured.Unstructured); ok {
+				handler(o)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldO, ok1 := oldObj.(*unstructured.Unstructured)
+			newO, ok2 := newObj.(*unstructured.Unstructured)
+
+			if !ok1 || !ok2 || oldO.GetResourceVersion() == newO.GetResourceVersion() {
+				return
+			}
+
+			handler(newO)
+		},
+	})
+
+	factory.Start(stopCh)
+
+	for _, ok := range factory.WaitForCacheSync(stopCh) {
+		if !ok {
+			return fmt.Errorf("unable to sync informer for %s", k8sKind)
+		}
+	}
+
+	return nil
+}
+
+// CreateEvent creates an Event associated with an object of namespace
+func CreateEvent(kind string, name string, namespace string, eventType string, reason string, message string) error {
+	now := metav1.Now()
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s.", name),
+			Namespace:    namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       kind,
+			Name:       name,
+			Namespace:  namespace,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Source: corev1.EventSource{
+			Component: filepath.Base(os.Args[0]),
+		},
+	}
+
+	_, err := clientset.CoreV1().Events(namespace).Create(context.Background(), event, metav1.CreateOptions{})
+
+	return err
+}
+
 func createK8sClients() error {
 	var config *rest.Config
 
@@ -98,7 +253,9 @@ func createK8sClients() error {
 	return nil
 }
 
-func CreateObject(k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string, rawObj string) error {
+// CreateObject creates the object from its YAML manifest and returns the object created
+func CreateObject(ctx context.Context, k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string,
+	rawObj string) (*unstructured.Unstructured, error) {
 	resource := schema.GroupVersionResource{Group: k8sApiGroup, Version: k8sApiVersion,
 		Resource: strings.ToLower(fmt.Sprintf("%ss", k8sKind))}
 
@@ -110,10 +267,93 @@ func CreateObject(k8sApiGroup string, k8sApiVersion string, k8sKind string, name
 	_, _, err := dec.Decode([]byte(rawObj), nil, obj)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = dynClient.Resource(resource).Namespace(namespace).Create(context.Background(), obj, metav1.CreateOptions{})
+	ctx, span := tracing.Start(ctx, fmt.Sprintf("create %s", k8sKind))
+
+	start := time.Now()
+
+	created, err := dynClient.Resource(resource).Namespace(namespace).Create(ctx, obj, metav1.CreateOptions{})
+
+	metrics.ObserveK8sRequest("create", k8sKind, start, err)
+	tracing.End(span, err)
+
+	return created, err
+}
+
+// ListObjects returns the objects of namespace that match labelSelector
+func ListObjects(ctx context.Context, k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string,
+	labelSelector string) ([]unstructured.Unstructured, error) {
+	resource := schema.GroupVersionResource{Group: k8sApiGroup, Version: k8sApiVersion,
+		Resource: strings.ToLower(fmt.Sprintf("%ss", k8sKind))}
+
+	ctx, span := tracing.Start(ctx, fmt.Sprintf("list %s", k8sKind))
+
+	start := time.Now()
+
+	list, err := dynClient.Resource(resource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+
+	metrics.ObserveK8sRequest("list", k8sKind, start, err)
+	tracing.End(span, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// PatchObject applies a JSON merge patch to the object
+func PatchObject(ctx context.Context, k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string,
+	name string, patch []byte) error {
+	resource := schema.GroupVersionResource{Group: k8sApiGroup, Version: k8sApiVersion,
+		Resource: strings.ToLower(fmt.Sprintf("%ss", k8sKind))}
+
+	ctx, span := tracing.Start(ctx, fmt.Sprintf("patch %s", k8sKind))
+
+	start := time.Now()
+
+	_, err := dynClient.Resource(resource).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+
+	metrics.ObserveK8sRequest("patch", k8sKind, start, err)
+	tracing.End(span, err)
+
+	return err
+}
+
+// DeleteObject deletes the object
+func DeleteObject(ctx context.Context, k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string,
+	name string) error {
+	resource := schema.GroupVersionResource{Group: k8sApiGroup, Version: k8sApiVersion,
+		Resource: strings.ToLower(fmt.Sprintf("%ss", k8sKind))}
+
+	ctx, span := tracing.Start(ctx, fmt.Sprintf("delete %s", k8sKind))
+
+	start := time.Now()
+
+	err := dynClient.Resource(resource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+
+	metrics.ObserveK8sRequest("delete", k8sKind, start, err)
+	tracing.End(span, err)
 
 	return err
 }
+
+// GetObject returns the object
+func GetObject(ctx context.Context, k8sApiGroup string, k8sApiVersion string, k8sKind string, namespace string,
+	name string) (*unstructured.Unstructured, error) {
+	resource := schema.GroupVersionResource{Group: k8sApiGroup, Version: k8sApiVersion,
+		Resource: strings.ToLower(fmt.Sprintf("%ss", k8sKind))}
+
+	ctx, span := tracing.Start(ctx, fmt.Sprintf("get %s", k8sKind))
+
+	start := time.Now()
+
+	obj, err := dynClient.Resource(resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+
+	metrics.ObserveK8sRequest("get", k8sKind, start, err)
+	tracing.End(span, err)
+
+	return obj, err
+}
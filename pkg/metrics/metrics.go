@@ -0,0 +1,278 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace string = "custom_tekton_listener"
+
+	// Results of the when conditions
+	WhenMatched    string = "matched"
+	WhenNotMatched string = "not_matched"
+	WhenError      string = "error"
+
+	// Event of the webhooks not verified with a secret and not found in knownEvents
+	EventUnknown string = "unknown"
+)
+
+// Note: The label pipeline is only set with pipelines found in the configuration, to avoid
+// creating series with any value sent in the query string. Likewise, the label event is only set
+// after verifying the signature of the webhook, or with the events in knownEvents if the pipeline
+// does not have a secret
+var (
+	webhooksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_received_total",
+		Help:      "Webhooks received by provider and event.",
+	}, []string{"provider", "event"})
+
+	signatureFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signature_failures_total",
+		Help:      "Webhooks rejected because of a wrong signature or token.",
+	}, []string{"provider", "pipeline"})
+
+	sourceIpRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_ip_rejections_total",
+		Help:      "Webhooks rejected because of the source ip.",
+	}, []string{"provider"})
+
+	whenEvaluations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "when_evaluations_total",
+		Help:      "Outcome of the when conditions by pipeline (matched, not_matched or error).",
+	}, []string{"pipeline", "result"})
+
+	pipelineRunsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_created_total",
+		Help:      "PipelineRuns created by pipeline.",
+	}, []string{"pipeline"})
+
+	pipelineRunsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_failed_total",
+		Help:      "PipelineRuns that could not be rendered or created by pipeline.",
+	}, []string{"pipeline"})
+
+	redeliveriesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redeliveries_skipped_total",
+		Help:      "Deliveries received again that did not launch a PipelineRun.",
+	}, []string{"pipeline"})
+
+	pipelineRunsSuperseded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_superseded_total",
+		Help:      "PipelineRuns cancelled or deleted because a newer one with the same concurrency key was created.",
+	}, []string{"pipeline"})
+
+	pipelineRunsCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_cancelled_total",
+		Help:      "PipelineRuns cancelled with the command /cancel in a pull request.",
+	}, []string{"pipeline"})
+
+	pipelineRunsQueued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_queued_total",
+		Help:      "PipelineRuns created as pending because the pipeline reached maxConcurrent.",
+	}, []string{"pipeline"})
+
+	pipelineRunsPromoted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_promoted_total",
+		Help:      "Pending PipelineRuns started when the pipeline had free slots.",
+	}, []string{"pipeline"})
+
+	pipelineRunCreateRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelinerun_create_retries_total",
+		Help:      "Attempts to create a PipelineRun retried after a transient error.",
+	}, []string{"pipeline"})
+
+	deadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Requests that failed permanently and were saved as dead letters.",
+	})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Requests waiting in the queue for a free worker.",
+	})
+
+	queueRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_rejections_total",
+		Help:      "Requests rejected (503) because the queue was full.",
+	})
+
+	workersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Workers processing a request.",
+	})
+
+	k8sRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "k8s_request_duration_seconds",
+		Help:      "Latency of the requests to the Kubernetes API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "kind", "success"})
+
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads by result (success or failure).",
+	}, []string{"result"})
+
+	configLastReloadSuccessful = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload was successful (1) or rejected (0).",
+	})
+
+	configLastReloadTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_timestamp_seconds",
+		Help:      "Timestamp of the last configuration reload, successful or not.",
+	})
+)
+
+func init() {
+	// The configuration loaded at startup is valid, otherwise the listener does not start
+	configLastReloadSuccessful.Set(1)
+}
+
+// Handler returns the handler that exposes the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Events of the providers that can be set in the label event without a secret
+var knownEvents = map[string][]string{
+	"github": {"push", "pull_request", "issue_comment", "create", "delete", "release", "workflow_run",
+		"check_run", "check_suite", "deployment", "status"},
+	"gitlab": {"Push Hook", "Tag Push Hook", "Merge Request Hook", "Note Hook", "Pipeline Hook", "Job Hook",
+		"Release Hook", "Deployment Hook"},
+	"bitbucket": {"repo:push", "pullrequest:created", "pullrequest:updated", "pullrequest:fulfilled",
+		"pullrequest:rejected", "pullrequest:comment_created"},
+	"gitea": {"push", "create", "delete", "pull_request", "pull_request_sync", "issue_comment", "release"},
+}
+
+// WebhookReceived records a webhook. verified is true if the signature of the webhook was
+// verified with a secret, otherwise the event is only recorded if it is known
+func WebhookReceived(provider string, event string, verified bool) {
+	if !verified && !isKnownEvent(provider, event) {
+		event = EventUnknown
+	}
+
+	webhooksReceived.WithLabelValues(provider, event).Inc()
+}
+
+func isKnownEvent(provider string, event string) bool {
+	for _, e := range knownEvents[provider] {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func SignatureFailure(provider string, pipeline string) {
+	signatureFailures.WithLabelValues(provider, pipeline).Inc()
+}
+
+func SourceIpRejected(provider string) {
+	sourceIpRejections.WithLabelValues(provider).Inc()
+}
+
+// WhenEvaluated records the outcome of the when conditions (WhenMatched, WhenNotMatched
+// or WhenError)
+func WhenEvaluated(pipeline string, result string) {
+	whenEvaluations.WithLabelValues(pipeline, result).Inc()
+}
+
+func PipelineRunCreated(pipeline string) {
+	pipelineRunsCreated.WithLabelValues(pipeline).Inc()
+}
+
+func PipelineRunFailed(pipeline string) {
+	pipelineRunsFailed.WithLabelValues(pipeline).Inc()
+}
+
+func RedeliverySkipped(pipeline string) {
+	redeliveriesSkipped.WithLabelValues(pipeline).Inc()
+}
+
+func PipelineRunsSuperseded(pipeline string, count int) {
+	pipelineRunsSuperseded.WithLabelValues(pipeline).Add(float64(count))
+}
+
+func PipelineRunCreateRetried(pipeline string) {
+	pipelineRunCreateRetries.WithLabelValues(pipeline).Inc()
+}
+
+func DeadLettered() {
+	deadLetters.Inc()
+}
+
+func PipelineRunsCancelled(pipeline string, count int) {
+	pipelineRunsCancelled.WithLabelValues(pipeline).Add(float64(count))
+}
+
+func PipelineRunQueued(pipeline string) {
+	pipelineRunsQueued.WithLabelValues(pipeline).Inc()
+}
+
+func PipelineRunPromoted(pipeline string) {
+	pipelineRunsPromoted.WithLabelValues(pipeline).Inc()
+}
+
+func SetQueueDepth(depth int) {
+	queueDepth.Set(float64(depth))
+}
+
+func QueueRejected() {
+	queueRejections.Inc()
+}
+
+// WorkerBusy adds delta (1 or -1) to the busy workers
+func WorkerBusy(delta int) {
+	workersBusy.Add(float64(delta))
+}
+
+// ObserveK8sRequest records the latency of a request to the Kubernetes API started at start
+func ObserveK8sRequest(operation string, kind string, start time.Time, err error) {
+	success := "true"
+
+	if err != nil {
+		success = "false"
+	}
+
+	k8sRequestDuration.WithLabelValues(operation, kind, success).Observe(time.Since(start).Seconds())
+}
+
+func ConfigReloaded(err error) {
+	configLastReloadTimestamp.SetToCurrentTime()
+
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		configLastReloadSuccessful.Set(0)
+
+		return
+	}
+
+	configReloads.WithLabelValues("success").Inc()
+	configLastReloadSuccessful.Set(1)
+}
@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/watcher"
+)
+
+const (
+	defaultMessage string = `PipelineRun {{ .Name }} of pipeline {{ .Pipeline }} {{ .State }}` +
+		`{{ if .Repo }} ({{ .Repo }}{{ if .Sha }}@{{ .Sha | trunc 7 }}{{ end }}){{ end }}` +
+		`{{ if .Message }}: {{ .Message }}{{ end }}`
+
+	defaultSubject string = `[tekton] Pipeline {{ .Pipeline }} {{ .State }}`
+
+	// Maximum time to send a notification
+	sendTimeout time.Duration = 30 * time.Second
+)
+
+// sink sends a notification
+type sink interface {
+	send(ctx context.Context, n *config.Notification, run *watcher.Run, message string) error
+}
+
+var sinks = map[string]sink{
+	config.NotificationTypeWebhook: &webhookSink{},
+	config.NotificationTypeSlack:   &slackSink{},
+	config.NotificationTypeSmtp:    &smtpSink{},
+}
+
+// Notifier sends the notifications configured in the pipelines when their PipelineRuns finish
+type Notifier struct{}
+
+func New() *Notifier {
+	return &Notifier{}
+}
+
+func (n *Notifier) Name() string {
+	return "notifications"
+}
+
+func (n *Notifier) Wants(run *watcher.Run) bool {
+	return run.IsDone() && len(notifications(run)) > 0
+}
+
+// Notify sends all notifications of the pipeline for the state of the run
+func (n *Notifier) Notify(ctx context.Context, run *watcher.Run) error {
+	var errs []string
+
+	for _, notification := range notifications(run) {
+		notificationType := strings.ToLower(notification.Type)
+
+		message, err := render(notification.Message, defaultMessage, run)
+
+		if err == nil {
+			sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+
+			err = sinks[notificationType].send(sendCtx, notification, run, message)
+
+			cancel()
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", notificationType, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// notifications returns the notifications of the pipeline to send in the state of the run
+func notifications(run *watcher.Run) []*config.Notification {
+	pipeline := config.GetPipeline(run.Pipeline)
+
+	if pipeline == nil {
+		return nil
+	}
+
+	var result []*config.Notification
+
+	for i := range pipeline.Notifications {
+		n := &pipeline.Notifications[i]
+
+		if _, ok := sinks[strings.ToLower(n.Type)]; ok && n.Notifies(run.State) {
+			result = append(result, n)
+		}
+	}
+
+	return result
+}
+
+// render executes the template, or def if it is empty
+func render(tpl string, def string, run *watcher.Run) (string, error) {
+	if len(tpl) == 0 {
+		tpl = def
+	}
+
+	t, err := template.New("message").Funcs(sprig.TxtFuncMap()).Parse(tpl)
+
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+
+	err = t.Execute(&out, run)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/watcher"
+)
+
+const defaultSmtpPort int = 587
+
+// smtpSink sends the message by email
+//
+// Note: The connection is upgraded to TLS if the server supports STARTTLS, and the credentials
+// are only sent over TLS (or to localhost)
+type smtpSink struct{}
+
+func (s *smtpSink) send(ctx context.Context, n *config.Notification, run *watcher.Run, message string) error {
+	subject, err := render(n.Subject, defaultSubject, run)
+
+	if err != nil {
+		return err
+	}
+
+	port := n.Smtp.Port
+
+	if port == 0 {
+		port = defaultSmtpPort
+	}
+
+	addr := net.JoinHostPort(n.Smtp.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+
+	if len(n.Smtp.Username) > 0 {
+		auth = smtp.PlainAuth("", n.Smtp.Username, n.Secret, n.Smtp.Host)
+	}
+
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", n.Smtp.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.Smtp.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n%s\r\n", strings.ReplaceAll(message, "\n", "\r\n"))
+
+	return sendMail(ctx, addr, n.Smtp.Host, auth, n.Smtp.From, n.Smtp.To, msg.Bytes())
+}
+
+// sendMail does the same as smtp.SendMail, but the connection is closed when ctx is done
+func sendMail(ctx context.Context, addr string, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Note: Closing the connection interrupts the command in progress if ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+
+		if err != nil {
+			return contextError(ctx, err)
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+
+		err = c.Auth(auth)
+
+		if err != nil {
+			return contextError(ctx, err)
+		}
+	}
+
+	err = c.Mail(from)
+
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	for _, addr := range to {
+		err = c.Rcpt(addr)
+
+		if err != nil {
+			return contextError(ctx, err)
+		}
+	}
+
+	w, err := c.Data()
+
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	_, err = w.Write(msg)
+
+	if err == nil {
+		err = w.Close()
+	}
+
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	return contextError(ctx, c.Quit())
+}
+
+// contextError returns the error of ctx if it is done, which is the cause of the error of the
+// connection
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
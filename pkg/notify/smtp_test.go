@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSmtpServer accepts one connection and runs handler with it
+func newSmtpServer(t *testing.T, handler func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		handler(conn)
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestSendMail(t *testing.T) {
+	received := make(chan string, 1)
+
+	addr := newSmtpServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+
+		var data strings.Builder
+		inData := false
+
+		for {
+			line, err := r.ReadString('\n')
+
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					conn.Write([]byte("250 OK\r\n"))
+				} else {
+					data.WriteString(line)
+				}
+
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				conn.Write([]byte("250-localhost\r\n250 8BITMIME\r\n"))
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				conn.Write([]byte("354 Go ahead\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				conn.Write([]byte("221 Bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := sendMail(ctx, addr, "localhost", nil, "tekton@example.com", []string{"team@example.com"},
+		[]byte("Subject: build failed\r\n\r\nbuild-abc failed\r\n"))
+
+	if err != nil {
+		t.Fatalf("sendMail() error = %v", err)
+	}
+
+	if msg := <-received; !strings.Contains(msg, "build-abc failed") {
+		t.Errorf("message received %q", msg)
+	}
+}
+
+func TestSendMailTimeout(t *testing.T) {
+	// The server accepts the connection but does not respond
+	done := make(chan struct{})
+
+	addr := newSmtpServer(t, func(conn net.Conn) {
+		<-done
+	})
+
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err := sendMail(ctx, addr, "localhost", nil, "tekton@example.com", []string{"team@example.com"}, []byte("build-abc failed"))
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sendMail() error = %v, want the deadline exceeded", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendMail() returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/watcher"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookSink posts the PipelineRun and the message in JSON
+type webhookSink struct{}
+
+// webhookBody is the body posted by webhookSink
+type webhookBody struct {
+	Message        string     `json:"message"`
+	Name           string     `json:"name"`
+	Namespace      string     `json:"namespace"`
+	ID             string     `json:"id"`
+	Pipeline       string     `json:"pipeline"`
+	State          string     `json:"state"`
+	Reason         string     `json:"reason,omitempty"`
+	Repo           string     `json:"repo,omitempty"`
+	Sha            string     `json:"sha,omitempty"`
+	StartTime      *time.Time `json:"startTime,omitempty"`
+	CompletionTime *time.Time `json:"completionTime,omitempty"`
+}
+
+func (s *webhookSink) send(ctx context.Context, n *config.Notification, run *watcher.Run, message string) error {
+	return postJson(ctx, n.GetUrl(), &webhookBody{
+		Message:        message,
+		Name:           run.Name,
+		Namespace:      run.Namespace,
+		ID:             run.ID,
+		Pipeline:       run.Pipeline,
+		State:          run.State,
+		Reason:         run.Reason,
+		Repo:           run.Repo,
+		Sha:            run.Sha,
+		StartTime:      run.StartTime,
+		CompletionTime: run.CompletionTime,
+	})
+}
+
+// slackSink posts the message to a Slack incoming webhook (or a compatible one, e.g. Mattermost)
+type slackSink struct{}
+
+func (s *slackSink) send(ctx context.Context, n *config.Notification, run *watcher.Run, message string) error {
+	return postJson(ctx, n.GetUrl(), map[string]string{"text": message})
+}
+
+func postJson(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := httpClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(res.Body)
+
+		return fmt.Errorf("server responded %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+)
+
+const (
+	DefaultWorkers   int = 10
+	DefaultQueueSize int = 100
+)
+
+// Pool runs the jobs in a fixed number of workers. The jobs wait in a bounded queue until a
+// worker is free
+type Pool struct {
+	jobs chan func()
+
+	// Jobs queued or running
+	pending sync.WaitGroup
+	count   int64
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewPool starts workers that run the jobs of a queue of size jobs
+func NewPool(workers int, size int) *Pool {
+	p := &Pool{
+		jobs: make(chan func(), size),
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit queues the job. It returns false if the queue is full or the pool is closed
+func (p *Pool) Submit(job func()) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	p.pending.Add(1)
+
+	select {
+	case p.jobs <- job:
+		atomic.AddInt64(&p.count, 1)
+		metrics.SetQueueDepth(len(p.jobs))
+
+		return true
+	default:
+		p.pending.Done()
+		metrics.QueueRejected()
+
+		return false
+	}
+}
+
+// Close stops accepting jobs and waits until the queued and running jobs finish or ctx is done
+func (p *Pool) Close(ctx context.Context) error {
+	p.mutex.Lock()
+
+	if !p.closed {
+		p.closed = true
+
+		// Note: The workers exit when the queue is empty
+		close(p.jobs)
+	}
+
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		p.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d request(s) still queued or in progress", atomic.LoadInt64(&p.count))
+	}
+}
+
+func (p *Pool) work() {
+	for job := range p.jobs {
+		metrics.SetQueueDepth(len(p.jobs))
+		metrics.WorkerBusy(1)
+
+		job()
+
+		metrics.WorkerBusy(-1)
+		atomic.AddInt64(&p.count, -1)
+		p.pending.Done()
+	}
+}
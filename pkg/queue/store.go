@@ -0,0 +1,265 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	recordExtension string = ".json"
+
+	redacted string = "[redacted]"
+)
+
+// Headers that carry the secrets of the webhooks (e.g. the token of GitLab or the bearer token of
+// CloudEvents), they are not kept in the dead letters
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Gitlab-Token"}
+
+// Record is a request accepted but not processed yet, or a request that failed (dead letter)
+type Record struct {
+	ID         string      `json:"id"`
+	Provider   string      `json:"provider"`
+	Method     string      `json:"method"`
+	Url        string      `json:"url"` // Path and query
+	Header     http.Header `json:"header"`
+	Payload    []byte      `json:"payload"`
+	Event      string      `json:"event"`
+	DeliveryId string      `json:"deliveryId,omitempty"`
+	ReceivedAt time.Time   `json:"receivedAt"`
+
+	// Only set in the dead letters
+	Reason   string     `json:"reason,omitempty"`
+	FailedAt *time.Time `json:"failedAt,omitempty"`
+
+	// SignatureVerified is true if the signature was verified before the request failed. The
+	// dead letters do not keep the credentials, so they are not verified again when replayed
+	SignatureVerified bool `json:"signatureVerified,omitempty"`
+}
+
+// RedactHeader returns a copy of the header without the values of the credentials
+func RedactHeader(header http.Header) http.Header {
+	result := header.Clone()
+
+	for _, name := range credentialHeaders {
+		if _, ok := result[http.CanonicalHeaderKey(name)]; ok {
+			result.Set(name, redacted)
+		}
+	}
+
+	return result
+}
+
+// Store keeps the records in a directory, one file per record, so they survive a restart
+type Store struct {
+	dir string
+}
+
+// NewStore creates the directory if it does not exist
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0700)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Save writes the record. The file is written to a temporary file and renamed, so a crash
+// does not leave a partial record
+func (s *Store) Save(record *Record) error {
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), s.path(record.ID))
+
+	if err != nil {
+		return err
+	}
+
+	// Persist the rename
+	dir, err := os.Open(s.dir)
+
+	if err != nil {
+		return err
+	}
+
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// Done removes the record
+func (s *Store) Done(id string) error {
+	err := os.Remove(s.path(id))
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List returns the records sorted from oldest to newest. The records that cannot be read are
+// skipped and reported in the error, along with the rest of records
+func (s *Store) List() ([]*Record, error) {
+	files, err := s.files()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	var errs []string
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, f.Name()))
+
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		var record Record
+
+		err = json.Unmarshal(data, &record)
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("malformed record %s: %s", f.Name(), err.Error()))
+			continue
+		}
+
+		records = append(records, &record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ReceivedAt.Before(records[j].ReceivedAt)
+	})
+
+	if len(errs) > 0 {
+		return records, errors.New(strings.Join(errs, "; "))
+	}
+
+	return records, nil
+}
+
+// Get returns the record, or nil if it does not exist
+func (s *Store) Get(id string) (*Record, error) {
+	// Note: The id may come from a http request
+	if len(id) == 0 || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(s.path(id))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var record Record
+
+	err = json.Unmarshal(data, &record)
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed record %s: %s", id, err.Error())
+	}
+
+	return &record, nil
+}
+
+// Trim removes the oldest records until there are max records. It returns the number of
+// records removed
+//
+// Note: The records are sorted by the modification time of the files, without reading them, so
+// it can be called after every Save
+func (s *Store) Trim(max int) (int, error) {
+	files, err := s.files()
+
+	if err != nil || len(files) <= max {
+		return 0, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].ModTime().Equal(files[j].ModTime()) {
+			return files[i].Name() < files[j].Name()
+		}
+
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	removed := 0
+
+	for _, f := range files[:len(files)-max] {
+		err = os.Remove(filepath.Join(s.dir, f.Name()))
+
+		if err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+// files returns the files of the records
+func (s *Store) files() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(s.dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []os.FileInfo
+
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || filepath.Ext(f.Name()) != recordExtension {
+			continue
+		}
+
+		result = append(result, f)
+	}
+
+	return result, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+recordExtension)
+}
@@ -0,0 +1,318 @@
+package queue
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	s, err := NewStore(filepath.Join(t.TempDir(), "queue"))
+
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	return s
+}
+
+// saveRecords saves a record per id, received and modified one minute after the previous one
+func saveRecords(t *testing.T, s *Store, ids ...string) {
+	start := time.Now().Add(-time.Hour)
+
+	for i, id := range ids {
+		at := start.Add(time.Duration(i) * time.Minute)
+
+		err := s.Save(&Record{ID: id, Provider: "github", ReceivedAt: at})
+
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		err = os.Chtimes(s.path(id), at, at)
+
+		if err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+	}
+}
+
+func listIds(t *testing.T, s *Store) []string {
+	records, err := s.List()
+
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	var ids []string
+
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+
+	return ids
+}
+
+func TestStoreSave(t *testing.T) {
+	s := newTestStore(t)
+
+	record := &Record{
+		ID:         "abc",
+		Provider:   "github",
+		Method:     http.MethodPost,
+		Url:        "/api/v1/github?pipeline=build&prefix=build",
+		Header:     http.Header{"X-Github-Event": {"push"}},
+		Payload:    []byte(`{"ref":"refs/heads/main"}`),
+		Event:      "push",
+		DeliveryId: "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+		ReceivedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	if err := s.Save(record); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Saved again, e.g. after a failed attempt
+	if err := s.Save(record); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	records, err := s.List()
+
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	if len(records) != 1 || !reflect.DeepEqual(records[0], record) {
+		t.Errorf("List() = %+v, want %+v", records, record)
+	}
+
+	// The temporary files are removed
+	files, err := ioutil.ReadDir(s.dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("%d files in the store, want 1", len(files))
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	s := newTestStore(t)
+
+	if ids := listIds(t, s); len(ids) != 0 {
+		t.Fatalf("List() = %v, want no records", ids)
+	}
+
+	// Saved in another order than received
+	now := time.Now()
+
+	for _, record := range []*Record{
+		{ID: "second", ReceivedAt: now.Add(-time.Minute)},
+		{ID: "third", ReceivedAt: now},
+		{ID: "first", ReceivedAt: now.Add(-time.Hour)},
+	} {
+		if err := s.Save(record); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	// Files that are not records are ignored
+	for _, name := range []string{".tmp-123", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(s.dir, name), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(s.dir, "dir.json"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids, want := listIds(t, s), []string{"first", "second", "third"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() = %v, want %v", ids, want)
+	}
+
+	// The malformed records are reported, but do not hide the rest
+	if err := ioutil.WriteFile(s.path("malformed"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.List()
+
+	if err == nil {
+		t.Errorf("List() error = nil, want the malformed record")
+	}
+
+	if len(records) != 3 {
+		t.Errorf("List() returned %d records, want 3", len(records))
+	}
+}
+
+func TestStoreGet(t *testing.T) {
+	s := newTestStore(t)
+
+	saveRecords(t, s, "abc")
+
+	if err := ioutil.WriteFile(s.path("malformed"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		found   bool
+		wantErr bool
+	}{
+		{name: "existing", id: "abc", found: true},
+		{name: "missing", id: "xyz"},
+		{name: "empty", id: ""},
+		{name: "hidden", id: ".tmp-123"},
+		{name: "path", id: "../queue/abc"},
+		{name: "windows path", id: `..\abc`},
+		{name: "malformed", id: "malformed", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := s.Get(tt.id)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+
+			if (record != nil) != tt.found {
+				t.Errorf("Get(%q) = %+v, found %v", tt.id, record, tt.found)
+			}
+
+			if record != nil && record.ID != tt.id {
+				t.Errorf("Get(%q) returned the record %s", tt.id, record.ID)
+			}
+		})
+	}
+}
+
+func TestStoreDone(t *testing.T) {
+	s := newTestStore(t)
+
+	saveRecords(t, s, "a", "b")
+
+	if err := s.Done("a"); err != nil {
+		t.Errorf("Done() error = %v", err)
+	}
+
+	// Already removed
+	if err := s.Done("a"); err != nil {
+		t.Errorf("Done() of a removed record error = %v", err)
+	}
+
+	if ids, want := listIds(t, s), []string{"b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() = %v, want %v", ids, want)
+	}
+}
+
+func TestStoreTrim(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		removed int
+		want    []string
+	}{
+		{"below the limit", 5, 0, []string{"a", "b", "c", "d"}},
+		{"at the limit", 4, 0, []string{"a", "b", "c", "d"}},
+		{"above the limit", 2, 2, []string{"c", "d"}},
+		{"all", 0, 4, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+
+			saveRecords(t, s, "a", "b", "c", "d")
+
+			removed, err := s.Trim(tt.max)
+
+			if err != nil {
+				t.Fatalf("Trim() error = %v", err)
+			}
+
+			if removed != tt.removed {
+				t.Errorf("Trim() = %d, want %d", removed, tt.removed)
+			}
+
+			if ids := listIds(t, s); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("List() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreTrimByModTime(t *testing.T) {
+	s := newTestStore(t)
+
+	saveRecords(t, s, "a", "b", "c")
+
+	// The oldest file is removed, even if it is not the oldest request
+	old := time.Now().Add(-2 * time.Hour)
+
+	if err := os.Chtimes(s.path("c"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Malformed records are removed as well
+	if err := ioutil.WriteFile(s.path("malformed"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(s.path("malformed"), old.Add(-time.Minute), old.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := s.Trim(2)
+
+	if err != nil || removed != 2 {
+		t.Fatalf("Trim() = %d, %v, want 2 records removed", removed, err)
+	}
+
+	if ids, want := listIds(t, s), []string{"a", "b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() = %v, want %v", ids, want)
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("Authorization", "Bearer token")
+	header.Set("X-Gitlab-Token", "s3cret")
+	header.Set("Cookie", "session=abc")
+	header.Set("X-Gitlab-Event", "Push Hook")
+	header.Set("X-Hub-Signature-256", "sha256=abc")
+
+	result := RedactHeader(header)
+
+	want := http.Header{
+		"Authorization":       {redacted},
+		"X-Gitlab-Token":      {redacted},
+		"Cookie":              {redacted},
+		"X-Gitlab-Event":      {"Push Hook"},
+		"X-Hub-Signature-256": {"sha256=abc"},
+	}
+
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("RedactHeader() = %v, want %v", result, want)
+	}
+
+	// The original header is not modified
+	if got := header.Get("X-Gitlab-Token"); got != "s3cret" {
+		t.Errorf("RedactHeader() modified the header: X-Gitlab-Token = %q", got)
+	}
+
+	if _, ok := result["Proxy-Authorization"]; ok {
+		t.Errorf("RedactHeader() added a header that was not set")
+	}
+}
@@ -0,0 +1,206 @@
+package tekton
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+const (
+	// Label with the name of the pipeline, used to count the PipelineRuns of a pipeline
+	PipelineNameLabel string = "pipeline-name"
+
+	// Value of spec.status to create a PipelineRun that does not start until it is removed
+	PipelineRunPending string = "PipelineRunPending"
+)
+
+// Note: The PipelineRuns of a pipeline are counted and promoted one at a time in this instance,
+// the rest of pipelines are not blocked (the creation may take a while when it is retried). If
+// there are several replicas, the limit can be exceeded briefly when they create PipelineRuns
+// of the same pipeline at the same time
+var (
+	// Lock of each pipeline, a channel with one slot
+	pipelineLocks      = make(map[string]chan struct{})
+	pipelineLocksMutex sync.Mutex
+)
+
+func pipelineLock(pipelineName string) chan struct{} {
+	pipelineLocksMutex.Lock()
+	defer pipelineLocksMutex.Unlock()
+
+	lock, ok := pipelineLocks[pipelineName]
+
+	if !ok {
+		lock = make(chan struct{}, 1)
+		pipelineLocks[pipelineName] = lock
+	}
+
+	return lock
+}
+
+// lockPipeline waits until the pipeline is free and locks it, it returns the function to
+// unlock it
+func lockPipeline(pipelineName string) func() {
+	lock := pipelineLock(pipelineName)
+
+	lock <- struct{}{}
+
+	return func() { <-lock }
+}
+
+// tryLockPipeline locks the pipeline only if it is free
+func tryLockPipeline(pipelineName string) (func(), bool) {
+	lock := pipelineLock(pipelineName)
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, true
+	default:
+		return nil, false
+	}
+}
+
+// startOrQueue creates the PipelineRun, as pending if the pipeline already has maxConcurrent
+// PipelineRuns running
+func (p *PipelineRun) startOrQueue(ctx context.Context, maxConcurrent int) error {
+	defer lockPipeline(p.PipelineName)()
+
+	running, _, err := listPipelineRuns(ctx, p.PipelineName)
+
+	if err != nil {
+		return fmt.Errorf("unable to count running pipelineruns: %s", err.Error())
+	}
+
+	p.Pending = len(running) >= maxConcurrent
+
+	err = p.create(ctx)
+
+	if err == nil && p.Pending {
+		metrics.PipelineRunQueued(p.PipelineName)
+	}
+
+	return err
+}
+
+// RunPromoter starts the pending PipelineRuns of the pipelines with maxConcurrent when they have
+// free slots. It checks every interval until stopCh is closed
+func RunPromoter(stopCh <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			for _, pipeline := range config.GetPipelines() {
+				if pipeline.MaxConcurrent <= 0 {
+					continue
+				}
+
+				promoted, err := promotePending(context.Background(), pipeline.Name, pipeline.MaxConcurrent)
+
+				if len(promoted) > 0 {
+					utils.Log("INFO", fmt.Sprintf("started pending pipelineruns of pipeline %s: %s", pipeline.Name,
+						strings.Join(promoted, ", ")))
+				}
+
+				if err != nil {
+					utils.Log("ERROR", fmt.Sprintf("unable to start pending pipelineruns of pipeline %s: %s", pipeline.Name,
+						err.Error()))
+				}
+			}
+		}
+	}
+}
+
+// promotePending starts the oldest pending PipelineRuns of the pipeline until it has maxConcurrent
+// PipelineRuns running. It returns the names of the PipelineRuns started
+//
+// Note: The pipeline is skipped if a PipelineRun is being created, it is checked again in the
+// next interval
+func promotePending(ctx context.Context, pipelineName string, maxConcurrent int) ([]string, error) {
+	unlock, ok := tryLockPipeline(pipelineName)
+
+	if !ok {
+		return nil, nil
+	}
+
+	defer unlock()
+
+	running, pending, err := listPipelineRuns(ctx, pipelineName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var promoted []string
+
+	for i := 0; i < len(pending) && len(running)+len(promoted) < maxConcurrent; i++ {
+		// Note: The resourceVersion makes the patch fail if the PipelineRun changed (e.g. it was
+		// cancelled or started by another instance)
+		patch := []byte(fmt.Sprintf(`{"metadata":{"resourceVersion":"%s"},"spec":{"status":null}}`,
+			pending[i].GetResourceVersion()))
+
+		err = k8s.PatchObject(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"),
+			pending[i].GetName(), patch)
+
+		if err != nil {
+			return promoted, fmt.Errorf("%s: %s", pending[i].GetName(), err.Error())
+		}
+
+		metrics.PipelineRunPromoted(pipelineName)
+
+		promoted = append(promoted, pending[i].GetName())
+	}
+
+	return promoted, nil
+}
+
+// listPipelineRuns returns the PipelineRuns of the pipeline that did not finish, split in running
+// and pending (sorted from oldest to newest)
+func listPipelineRuns(ctx context.Context, pipelineName string) ([]unstructured.Unstructured, []unstructured.Unstructured, error) {
+	items, err := k8s.ListObjects(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"),
+		fmt.Sprintf("%s=%s", PipelineNameLabel, pipelineName))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var running, pending []unstructured.Unstructured
+
+	for i := range items {
+		if isDone(&items[i]) {
+			continue
+		}
+
+		if isPending(&items[i]) {
+			pending = append(pending, items[i])
+		} else {
+			running = append(running, items[i])
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return isCreatedBefore(&pending[i], &pending[j])
+	})
+
+	return running, pending, nil
+}
+
+func isPending(obj *unstructured.Unstructured) bool {
+	status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+
+	return status == PipelineRunPending
+}
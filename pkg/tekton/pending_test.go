@@ -0,0 +1,482 @@
+package tekton
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+)
+
+const testNamespace string = "pipelines"
+
+var pipelineRunsResource = schema.GroupVersionResource{Group: tektonApiGroup, Version: tektonApiVersion, Resource: "pipelineruns"}
+
+// newTestClient sets a fake dynamic client with the PipelineRuns
+func newTestClient(t *testing.T, pipelineRuns ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	previous, ok := os.LookupEnv("PIPELINES_NAMESPACE")
+
+	os.Setenv("PIPELINES_NAMESPACE", testNamespace)
+
+	var objects []runtime.Object
+
+	for _, p := range pipelineRuns {
+		objects = append(objects, p)
+	}
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{pipelineRunsResource: "PipelineRunList"}, objects...)
+
+	k8s.SetDynamicClient(client)
+
+	t.Cleanup(func() {
+		k8s.SetDynamicClient(nil)
+
+		if ok {
+			os.Setenv("PIPELINES_NAMESPACE", previous)
+		} else {
+			os.Unsetenv("PIPELINES_NAMESPACE")
+		}
+	})
+
+	return client
+}
+
+// newPipelineRun returns a PipelineRun of the pipeline. state is running, pending or done
+func newPipelineRun(pipelineName string, name string, state string, created time.Time) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": tektonApiGroup + "/" + tektonApiVersion,
+		"kind":       pipelineRunKind,
+		"metadata": map[string]interface{}{
+			"name":              name,
+			"namespace":         testNamespace,
+			"resourceVersion":   "1" + name,
+			"creationTimestamp": created.UTC().Format(time.RFC3339),
+			"labels":            map[string]interface{}{PipelineNameLabel: pipelineName},
+		},
+		"spec": map[string]interface{}{
+			"pipelineRef": map[string]interface{}{"name": pipelineName},
+		},
+	}}
+
+	switch state {
+	case "pending":
+		unstructured.SetNestedField(obj.Object, PipelineRunPending, "spec", "status")
+	case "done":
+		unstructured.SetNestedSlice(obj.Object, []interface{}{
+			map[string]interface{}{"type": "Succeeded", "status": "True"},
+		}, "status", "conditions")
+	}
+
+	return obj
+}
+
+func getPipelineRun(t *testing.T, client *dynamicfake.FakeDynamicClient, name string) *unstructured.Unstructured {
+	obj, err := client.Tracker().Get(pipelineRunsResource, testNamespace, name)
+
+	if err != nil {
+		t.Fatalf("unable to get pipelinerun %s: %v", name, err)
+	}
+
+	return obj.(*unstructured.Unstructured)
+}
+
+func TestStartOrQueue(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		existing      []*unstructured.Unstructured
+		maxConcurrent int
+		pending       bool
+	}{
+		{
+			name:          "no pipelineruns",
+			maxConcurrent: 1,
+		},
+		{
+			name: "below the limit",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "running", now),
+			},
+			maxConcurrent: 2,
+		},
+		{
+			name: "at the limit",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "running", now),
+				newPipelineRun("build", "build-2", "running", now),
+			},
+			maxConcurrent: 2,
+			pending:       true,
+		},
+		{
+			name: "pending ones are not running",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "running", now),
+				newPipelineRun("build", "build-2", "pending", now),
+			},
+			maxConcurrent: 2,
+		},
+		{
+			name: "finished and other pipelines are not counted",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "done", now),
+				newPipelineRun("deploy", "deploy-1", "running", now),
+			},
+			maxConcurrent: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.existing...)
+
+			p := &PipelineRun{ID: "abc", PipelineName: "build", Prefix: "build", MaxConcurrent: tt.maxConcurrent}
+
+			err := p.Start(context.Background())
+
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			if p.Pending != tt.pending {
+				t.Errorf("Start() pending = %v, want %v", p.Pending, tt.pending)
+			}
+
+			created := getPipelineRun(t, client, "build-abc")
+
+			status, _, _ := unstructured.NestedString(created.Object, "spec", "status")
+
+			if (status == PipelineRunPending) != tt.pending {
+				t.Errorf("pipelinerun created with spec.status %q, pending %v", status, tt.pending)
+			}
+
+			if created.GetLabels()[PipelineNameLabel] != "build" {
+				t.Errorf("pipelinerun created without the label %s: %v", PipelineNameLabel, created.GetLabels())
+			}
+		})
+	}
+}
+
+func TestPromotePending(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		existing      []*unstructured.Unstructured
+		maxConcurrent int
+		promoted      []string
+	}{
+		{
+			name: "oldest first",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "running", now.Add(-time.Hour)),
+				newPipelineRun("build", "build-c", "pending", now.Add(-2*time.Minute)),
+				newPipelineRun("build", "build-a", "pending", now),
+				newPipelineRun("build", "build-b", "pending", now.Add(-3*time.Minute)),
+			},
+			maxConcurrent: 3,
+			promoted:      []string{"build-b", "build-c"},
+		},
+		{
+			name: "same creation time sorted by name",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-b", "pending", now),
+				newPipelineRun("build", "build-a", "pending", now),
+			},
+			maxConcurrent: 1,
+			promoted:      []string{"build-a"},
+		},
+		{
+			name: "no free slots",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "running", now),
+				newPipelineRun("build", "build-a", "pending", now),
+			},
+			maxConcurrent: 1,
+		},
+		{
+			name: "finished ones free slots",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("build", "build-1", "done", now),
+				newPipelineRun("build", "build-a", "pending", now),
+			},
+			maxConcurrent: 1,
+			promoted:      []string{"build-a"},
+		},
+		{
+			name: "other pipelines",
+			existing: []*unstructured.Unstructured{
+				newPipelineRun("deploy", "deploy-a", "pending", now),
+			},
+			maxConcurrent: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.existing...)
+
+			promoted, err := promotePending(context.Background(), "build", tt.maxConcurrent)
+
+			if err != nil {
+				t.Fatalf("promotePending() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(promoted, tt.promoted) {
+				t.Errorf("promotePending() = %v, want %v", promoted, tt.promoted)
+			}
+
+			for _, existing := range tt.existing {
+				obj := getPipelineRun(t, client, existing.GetName())
+
+				_, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "status")
+				wasPending := isPending(existing)
+				isPromoted := sliceContains(promoted, existing.GetName())
+
+				if wasPending && found == isPromoted {
+					t.Errorf("pipelinerun %s promoted %v, but spec.status found %v", existing.GetName(), isPromoted, found)
+				}
+			}
+		})
+	}
+}
+
+func TestPromotePendingPatch(t *testing.T) {
+	client := newTestClient(t, newPipelineRun("build", "build-a", "pending", time.Now()))
+
+	var patches []string
+
+	client.PrependReactor("patch", "pipelineruns", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, string(action.(k8stesting.PatchAction).GetPatch()))
+		return false, nil, nil
+	})
+
+	_, err := promotePending(context.Background(), "build", 1)
+
+	if err != nil {
+		t.Fatalf("promotePending() error = %v", err)
+	}
+
+	want := []string{`{"metadata":{"resourceVersion":"1build-a"},"spec":{"status":null}}`}
+
+	if !reflect.DeepEqual(patches, want) {
+		t.Errorf("patches = %v, want %v", patches, want)
+	}
+}
+
+func TestPromotePendingConflict(t *testing.T) {
+	now := time.Now()
+
+	client := newTestClient(t,
+		newPipelineRun("build", "build-a", "pending", now.Add(-time.Minute)),
+		newPipelineRun("build", "build-b", "pending", now),
+	)
+
+	// The PipelineRun changed since it was listed (e.g. it was started by another instance)
+	client.PrependReactor("patch", "pipelineruns", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(pipelineRunsResource.GroupResource(), "build-a", errors.New("modified"))
+	})
+
+	promoted, err := promotePending(context.Background(), "build", 2)
+
+	if err == nil || !strings.Contains(err.Error(), "build-a") {
+		t.Errorf("promotePending() error = %v, want a conflict of build-a", err)
+	}
+
+	if len(promoted) > 0 {
+		t.Errorf("promotePending() = %v after a conflict, want none", promoted)
+	}
+}
+
+func TestRunPromoter(t *testing.T) {
+	err := config.LoadConfigFromData([]byte(`
+pipelines:
+- name: build
+  maxConcurrent: 1
+- name: deploy
+`))
+
+	if err != nil {
+		t.Fatalf("LoadConfigFromData() error = %v", err)
+	}
+
+	client := newTestClient(t,
+		newPipelineRun("build", "build-a", "pending", time.Now()),
+		newPipelineRun("deploy", "deploy-a", "pending", time.Now()),
+	)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		RunPromoter(stopCh, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for isPending(getPipelineRun(t, client, "build-a")) {
+		if time.Now().After(deadline) {
+			t.Fatal("pending pipelinerun not started by the promoter")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stopCh)
+	<-done
+
+	// Pipelines without maxConcurrent are not managed by the promoter
+	if !isPending(getPipelineRun(t, client, "deploy-a")) {
+		t.Errorf("pipelinerun of a pipeline without maxConcurrent started by the promoter")
+	}
+}
+
+func TestDeliveryLabelValue(t *testing.T) {
+	tests := []struct {
+		deliveryId string
+		hashed     bool
+	}{
+		{"72d3162e-cc78-11e3-81ab-4c9367dc0958", false},
+		{"a1b2c3", false},
+		{"{6f3e2a1b-0c4d-4e5f-8a9b-1c2d3e4f5a6b}", true},
+		{"/builds/app#A234-1234-1234", true},
+		{strings.Repeat("a", 64), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.deliveryId, func(t *testing.T) {
+			got := DeliveryLabelValue(tt.deliveryId)
+
+			if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+				t.Errorf("DeliveryLabelValue() = %q, not a valid label value: %v", got, errs)
+			}
+
+			if (got != tt.deliveryId) != tt.hashed {
+				t.Errorf("DeliveryLabelValue() = %q, hashed %v", got, tt.hashed)
+			}
+
+			if got != DeliveryLabelValue(tt.deliveryId) {
+				t.Errorf("DeliveryLabelValue() is not stable")
+			}
+		})
+	}
+}
+
+func TestConcurrencyLabelValue(t *testing.T) {
+	values := []string{
+		ConcurrencyLabelValue("build", "refs/heads/main"),
+		ConcurrencyLabelValue("build", "refs/heads/feature"),
+		ConcurrencyLabelValue("deploy", "refs/heads/main"),
+		ConcurrencyLabelValue("build", "owner/repo#12 with spaces and {braces}"),
+	}
+
+	for _, value := range values {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			t.Errorf("ConcurrencyLabelValue() = %q, not a valid label value: %v", value, errs)
+		}
+	}
+
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] {
+			t.Errorf("ConcurrencyLabelValue() returned %q for different keys", sorted[i])
+		}
+	}
+
+	if values[0] != ConcurrencyLabelValue("build", "refs/heads/main") {
+		t.Errorf("ConcurrencyLabelValue() is not stable")
+	}
+}
+
+func sliceContains(s []string, str string) bool {
+	for _, item := range s {
+		if item == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestPipelineLocks(t *testing.T) {
+	client := newTestClient(t, newPipelineRun("build", "build-a", "pending", time.Now()))
+
+	// e.g. creating a PipelineRun of build while the API server is retried
+	unlock := lockPipeline("build")
+
+	if _, ok := tryLockPipeline("build"); ok {
+		t.Fatal("tryLockPipeline() locked a locked pipeline")
+	}
+
+	// The promoter skips the pipeline until the next interval
+	promoted, err := promotePending(context.Background(), "build", 1)
+
+	if err != nil || len(promoted) > 0 || !isPending(getPipelineRun(t, client, "build-a")) {
+		t.Errorf("promotePending() = %v, %v of a locked pipeline, want nothing promoted", promoted, err)
+	}
+
+	// The rest of pipelines are not blocked
+	started := make(chan error, 1)
+
+	go func() {
+		started <- (&PipelineRun{ID: "abc", PipelineName: "deploy", Prefix: "deploy", MaxConcurrent: 1}).Start(context.Background())
+	}()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() of another pipeline blocked by the lock of build")
+	}
+
+	// The same pipeline waits until it is unlocked
+	go func() {
+		started <- (&PipelineRun{ID: "abc", PipelineName: "build", Prefix: "build", MaxConcurrent: 1}).Start(context.Background())
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("Start() did not wait for the lock of the pipeline")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() blocked after unlocking the pipeline")
+	}
+
+	unlock, ok := tryLockPipeline("build")
+
+	if !ok {
+		t.Fatal("tryLockPipeline() did not lock a free pipeline")
+	}
+
+	unlock()
+}
@@ -0,0 +1,57 @@
+package tekton
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+)
+
+// PullRequestLabelValue returns the value of the label PullRequestLabel. The repository is hashed
+// because it can have characters not allowed in labels
+func PullRequestLabelValue(repo string, number int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d", strings.ToLower(repo), number)))
+
+	return hex.EncodeToString(sum[:])[:63]
+}
+
+// CancelPullRequest cancels the PipelineRuns of the pipeline for the pull request that did not
+// finish. It returns the names of the PipelineRuns cancelled
+func CancelPullRequest(ctx context.Context, pipelineName string, repo string, number int64) ([]string, error) {
+	items, err := k8s.ListObjects(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"),
+		fmt.Sprintf("%s=%s,%s=%s", PipelineNameLabel, pipelineName, PullRequestLabel, PullRequestLabelValue(repo, number)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cancelled []string
+	var errs []string
+
+	for i := range items {
+		if isDone(&items[i]) {
+			continue
+		}
+
+		done, err := cancel(ctx, &items[i])
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", items[i].GetName(), err.Error()))
+			continue
+		}
+
+		if done {
+			cancelled = append(cancelled, items[i].GetName())
+		}
+	}
+
+	if len(errs) > 0 {
+		return cancelled, fmt.Errorf("unable to cancel pipelineruns: %s", strings.Join(errs, "; "))
+	}
+
+	return cancelled, nil
+}
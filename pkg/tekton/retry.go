@@ -0,0 +1,142 @@
+package tekton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+const (
+	DefaultMaxAttempts    int           = 5
+	DefaultInitialBackoff time.Duration = time.Second
+	DefaultMaxBackoff     time.Duration = 30 * time.Second
+)
+
+// RetryPolicy configures the retries of the creation of the PipelineRuns
+type RetryPolicy struct {
+	// Attempts including the first one
+	MaxAttempts int
+
+	// Wait before the second attempt, it doubles in each attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var (
+	retryPolicy = RetryPolicy{
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+	}
+
+	randMutex sync.Mutex
+	random    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SetRetryPolicy changes the retries of the creation of the PipelineRuns
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicy = policy
+}
+
+// isRetryable checks if the request to the Kubernetes API failed by a transient error: too many
+// requests, server errors, timeouts, conflicts and connection errors. The rest of errors (e.g.
+// validation errors) fail again if retried
+func isRetryable(err error) bool {
+	switch {
+	case apierrors.IsTooManyRequests(err),
+		apierrors.IsServerTimeout(err),
+		apierrors.IsTimeout(err),
+		apierrors.IsConflict(err),
+		apierrors.IsInternalError(err),
+		apierrors.IsServiceUnavailable(err),
+		apierrors.IsUnexpectedServerError(err):
+		return true
+	}
+
+	var status apierrors.APIStatus
+
+	if errors.As(err, &status) {
+		return status.Status().Code >= 500
+	}
+
+	var netErr net.Error
+
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err)
+}
+
+// backoff returns the wait before the attempt (2 or later). The wait doubles in each attempt and
+// a random jitter is subtracted (up to half of it), so the instances do not retry at the same time
+func backoff(attempt int) time.Duration {
+	wait := retryPolicy.InitialBackoff
+
+	for i := 2; i < attempt && wait < retryPolicy.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > retryPolicy.MaxBackoff {
+		wait = retryPolicy.MaxBackoff
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	randMutex.Lock()
+	jitter := time.Duration(random.Int63n(int64(wait)/2 + 1))
+	randMutex.Unlock()
+
+	return wait - jitter
+}
+
+// withRetries calls fn until it succeeds, it fails with an error that is not retryable or the
+// attempts are exhausted. fn receives the number of attempt, starting at 1
+func (p *PipelineRun) withRetries(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+
+	attempt := 1
+
+	for ; ; attempt++ {
+		err = fn(attempt)
+
+		if err == nil {
+			return nil
+		}
+
+		if !isRetryable(err) {
+			break
+		}
+
+		if attempt >= retryPolicy.MaxAttempts {
+			break
+		}
+
+		wait := backoff(attempt + 1)
+
+		utils.LogContext(ctx, "WARNING", fmt.Sprintf("unable to create pipelinerun (attempt %d of %d), retrying in %s: %s",
+			attempt, retryPolicy.MaxAttempts, wait.Round(time.Millisecond), err.Error()))
+
+		metrics.PipelineRunCreateRetried(p.PipelineName)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unable to create pipelinerun after %d attempt(s): %s", attempt, ctx.Err().Error())
+		case <-time.After(wait):
+		}
+	}
+
+	return fmt.Errorf("unable to create pipelinerun after %d attempt(s): %s", attempt, err.Error())
+}
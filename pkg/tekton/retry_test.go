@@ -0,0 +1,183 @@
+package tekton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var pipelineRuns = schema.GroupResource{Group: tektonApiGroup, Resource: "pipelineruns"}
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"too many requests", apierrors.NewTooManyRequests("slow down", 1), true},
+		{"server timeout", apierrors.NewServerTimeout(pipelineRuns, "create", 1), true},
+		{"timeout", apierrors.NewTimeoutError("timeout", 1), true},
+		{"conflict", apierrors.NewConflict(pipelineRuns, "build-abc", errors.New("changed")), true},
+		{"internal error", apierrors.NewInternalError(errors.New("etcd")), true},
+		{"service unavailable", apierrors.NewServiceUnavailable("unavailable"), true},
+		{"bad gateway", apierrors.NewGenericServerResponse(502, "create", pipelineRuns, "", "", 0, true), true},
+		{"wrapped server error", fmt.Errorf("create: %w", apierrors.NewInternalError(errors.New("etcd"))), true},
+		{"net timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"eof", io.EOF, true},
+		{"bad request", apierrors.NewBadRequest("malformed"), false},
+		{"invalid", apierrors.NewInvalid(schema.GroupKind{Group: tektonApiGroup, Kind: pipelineRunKind}, "build-abc", nil), false},
+		{"already exists", apierrors.NewAlreadyExists(pipelineRuns, "build-abc"), false},
+		{"forbidden", apierrors.NewForbidden(pipelineRuns, "build-abc", errors.New("rbac")), false},
+		{"not found", apierrors.NewNotFound(pipelineRuns, "build-abc"), false},
+		{"other error", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func setTestRetryPolicy(t *testing.T, policy RetryPolicy) {
+	previous := retryPolicy
+
+	SetRetryPolicy(policy)
+
+	t.Cleanup(func() {
+		SetRetryPolicy(previous)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+	})
+
+	tests := []struct {
+		attempt int
+		max     time.Duration // The minimum is half of it
+	}{
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{5, 8 * time.Second},
+		{6, 16 * time.Second},
+		{7, 30 * time.Second},
+		{50, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				wait := backoff(tt.attempt)
+
+				if wait < tt.max/2 || wait > tt.max {
+					t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, wait, tt.max/2, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffWithoutWait(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{MaxAttempts: 3})
+
+	if wait := backoff(2); wait != 0 {
+		t.Errorf("backoff(2) = %s, want 0", wait)
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+
+	transient := apierrors.NewServiceUnavailable("unavailable")
+
+	tests := []struct {
+		name     string
+		errs     []error // Error of each attempt, nil after the last one
+		attempts int
+		wantErr  bool
+	}{
+		{"success", nil, 1, false},
+		{"success after retries", []error{transient, transient}, 3, false},
+		{"attempts exhausted", []error{transient, transient, transient, transient}, 3, true},
+		{"not retryable", []error{apierrors.NewBadRequest("malformed"), transient}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PipelineRun{PipelineName: "build"}
+			attempts := 0
+
+			err := p.withRetries(context.Background(), func(attempt int) error {
+				attempts++
+
+				if attempt != attempts {
+					t.Errorf("attempt %d, want %d", attempt, attempts)
+				}
+
+				if attempt <= len(tt.errs) {
+					return tt.errs[attempt-1]
+				}
+
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withRetries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if attempts != tt.attempts {
+				t.Errorf("withRetries() made %d attempt(s), want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestWithRetriesCancelled(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+
+	err := (&PipelineRun{PipelineName: "build"}).withRetries(ctx, func(attempt int) error {
+		attempts++
+		return apierrors.NewServiceUnavailable("unavailable")
+	})
+
+	if err == nil || attempts != 1 {
+		t.Errorf("withRetries() = %v after %d attempt(s), want an error after 1 attempt", err, attempts)
+	}
+}
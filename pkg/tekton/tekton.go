@@ -2,6 +2,7 @@ package tekton
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"os"
@@ -9,17 +10,44 @@ import (
 	"strings"
 	"time"
 
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 
 	"github.com/Masterminds/sprig"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+
 	"github.com/jaberchez/custom-tekton-listener/pkg/config"
 	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tracing"
 )
 
 const (
 	tektonApiGroup   string = "tekton.dev"
 	tektonApiVersion string = "v1beta1"
 	pipelineRunKind  string = "PipelineRun"
+
+	// Label with the id of the PipelineRun, set in all PipelineRuns created by the listener
+	PipelineRunIdLabel string = "pipelinerun-id"
+
+	// Annotations with the origin of the PipelineRun, set if the provider returns them
+	ProviderAnnotation string = "provider"
+	RepoAnnotation     string = "repo"
+	ShaAnnotation      string = "commit-sha"
+
+	// Label with the hash of the repository and the number of the pull request
+	PullRequestLabel string = "pull-request"
+
+	// Label with the id of the delivery set by the provider
+	DeliveryIdLabel string = "delivery-id"
+
+	// Label with the hash of the concurrency key (the key itself is set as annotation)
+	ConcurrencyKeyLabel string = "concurrency-key"
+
+	// Value of spec.status to cancel a PipelineRun
+	pipelineRunCancelled string = "Cancelled"
 )
 
 var workspacesTemplate string = `{{ define "workspaces" }}
@@ -45,6 +73,9 @@ metadata:
     {{ $key }}: "{{ $value }}"
     {{- end }}
 spec:
+  {{- if .Pending }}
+  status: %s
+  {{- end }}
   {{- $length := len .ServiceAccount }} {{ if gt $length 0 }}
   serviceAccountName: {{ .ServiceAccount }}
   {{- end }}
@@ -67,15 +98,15 @@ spec:
         name: {{ .ResourceRef }}
   {{- end }}
   {{- end }}
-`, tektonApiGroup, tektonApiVersion, pipelineRunKind)
+`, tektonApiGroup, tektonApiVersion, pipelineRunKind, PipelineRunPending)
 
 type PipelineRun struct {
 	ID             string
 	PipelineName   string
 	Namespace      string
 	Prefix         string
-	GitHubPayload  []byte
-	GitHubEvent    string
+	Payload        []byte
+	Event          string
 	Params         map[string]string
 	ExtraParams    map[string]string
 	Workspaces     []config.Workspace
@@ -83,26 +114,210 @@ type PipelineRun struct {
 	Labels         map[string]string
 	Annotations    map[string]string
 	ServiceAccount string
+	DeliveryId     string
+	ConcurrencyKey string
+
+	// Origin of the event, used to report the status of the PipelineRun (e.g. GitHub commit statuses)
+	Provider    string
+	Repo        string
+	Sha         string
+	PullRequest int64 // 0 if it is not related to a pull request
+
+	// If MaxConcurrent is greater than 0, the PipelineRun is created as pending when the pipeline
+	// has MaxConcurrent PipelineRuns running. Pending is set by Start
+	MaxConcurrent int
+	Pending       bool
+
+	// Object created by Start
+	created *unstructured.Unstructured
+}
+
+// Name returns the name of the PipelineRun
+func (p *PipelineRun) Name() string {
+	return fmt.Sprintf("%s-%s", p.Prefix, p.ID)
+}
+
+// Render returns the PipelineRun manifest without creating it
+func (p *PipelineRun) Render(ctx context.Context) (string, error) {
+	manifest, err := p.renderTemplate(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	// Remove the trailing spaces left by the template, so the manifest is more readable
+	lines := strings.Split(manifest, "\n")
+
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+
+	return strings.Join(lines, "\n"), nil
 }
 
-func (p *PipelineRun) Start() error {
-	tplStr, err := p.renderTemplate()
+func (p *PipelineRun) Start(ctx context.Context) error {
+	if p.MaxConcurrent > 0 {
+		return p.startOrQueue(ctx, p.MaxConcurrent)
+	}
+
+	return p.create(ctx)
+}
+
+func (p *PipelineRun) create(ctx context.Context) error {
+	tplStr, err := p.renderTemplate(ctx)
 
 	if err != nil {
 		return err
 	}
 
-	// Create PipelineRun in Kubernetes
-	return k8s.CreateObject(tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"), tplStr)
+	namespace := os.Getenv("PIPELINES_NAMESPACE")
+
+	// Create PipelineRun in Kubernetes, retrying the transient errors
+	return p.withRetries(ctx, func(attempt int) error {
+		p.created, err = k8s.CreateObject(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, namespace, tplStr)
+
+		// Note: A previous attempt that timed out may have created it
+		if attempt > 1 && apierrors.IsAlreadyExists(err) {
+			p.created, err = k8s.GetObject(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, namespace, p.Name())
+		}
+
+		return err
+	})
+}
+
+// SupersedeOlder cancels (config.ConcurrencyPolicyCancel) or deletes (config.ConcurrencyPolicyDelete)
+// the PipelineRuns still running with the same concurrency key that were created before this one.
+// It must be called after Start and returns the names of the PipelineRuns superseded
+//
+// Note: The PipelineRuns are sorted by creation time and name, so if several instances create
+// PipelineRuns with the same key at the same time, all of them keep the same one
+func (p *PipelineRun) SupersedeOlder(ctx context.Context, policy string) ([]string, error) {
+	if p.created == nil || len(p.ConcurrencyKey) == 0 {
+		return nil, nil
+	}
+
+	namespace := os.Getenv("PIPELINES_NAMESPACE")
+
+	items, err := k8s.ListObjects(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, namespace,
+		fmt.Sprintf("%s=%s", ConcurrencyKeyLabel, ConcurrencyLabelValue(p.PipelineName, p.ConcurrencyKey)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var superseded []string
+	var errs []string
+
+	for i := range items {
+		item := &items[i]
+
+		if item.GetName() == p.created.GetName() || !isCreatedBefore(item, p.created) || isDone(item) {
+			continue
+		}
+
+		done := true
+
+		if policy == config.ConcurrencyPolicyDelete {
+			err = k8s.DeleteObject(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, namespace, item.GetName())
+		} else {
+			done, err = cancel(ctx, item)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", item.GetName(), err.Error()))
+			continue
+		}
+
+		if done {
+			superseded = append(superseded, item.GetName())
+		}
+	}
+
+	if len(errs) > 0 {
+		return superseded, fmt.Errorf("unable to %s pipelineruns: %s", policy, strings.Join(errs, "; "))
+	}
+
+	return superseded, nil
+}
+
+// cancel cancels the PipelineRun. It returns false if it was already cancelled
+//
+// Note: The pending PipelineRuns are cancelled too
+func cancel(ctx context.Context, obj *unstructured.Unstructured) (bool, error) {
+	if status, _, _ := unstructured.NestedString(obj.Object, "spec", "status"); len(status) > 0 && status != PipelineRunPending {
+		return false, nil
+	}
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"status":"%s"}}`, pipelineRunCancelled))
+
+	err := k8s.PatchObject(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, obj.GetNamespace(), obj.GetName(), patch)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeliveryExists checks if a PipelineRun was already created for the delivery, by this
+// instance or by another one
+func DeliveryExists(ctx context.Context, deliveryId string) (bool, error) {
+	items, err := k8s.ListObjects(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"),
+		fmt.Sprintf("%s=%s", DeliveryIdLabel, DeliveryLabelValue(deliveryId)))
+
+	if err != nil {
+		return false, err
+	}
+
+	return len(items) > 0, nil
+}
+
+// ConcurrencyLabelValue returns the value of the label ConcurrencyKeyLabel. The key is hashed
+// along with the pipeline because it can have any character
+func ConcurrencyLabelValue(pipelineName string, key string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s", pipelineName, key)))
+
+	return hex.EncodeToString(sum[:])[:63]
+}
+
+// DeliveryLabelValue returns the value of the label DeliveryIdLabel. The ids that are not valid
+// label values (e.g. the ids of Bitbucket Cloud are between braces) are hashed
+func DeliveryLabelValue(deliveryId string) string {
+	if len(validation.IsValidLabelValue(deliveryId)) == 0 {
+		return deliveryId
+	}
+
+	sum := sha256.Sum256([]byte(deliveryId))
+
+	return hex.EncodeToString(sum[:])[:63]
 }
 
-func (p *PipelineRun) renderTemplate() (string, error) {
+func (p *PipelineRun) renderTemplate(ctx context.Context) (manifest string, err error) {
+	// Note: The PipelineRun is linked with the span of the caller, not with the span of rendering
+	spanContext := tracing.SpanContextAnnotationValue(ctx)
+
+	_, span := tracing.Start(ctx, "render pipelinerun")
+	defer func() { tracing.End(span, err) }()
+
 	p.Namespace = os.Getenv("PIPELINES_NAMESPACE")
 
 	// Set labels
 	labels := make(map[string]string)
 
-	labels["pipelinerun-id"] = p.ID
+	labels[PipelineRunIdLabel] = p.ID
+	labels[PipelineNameLabel] = p.PipelineName
+
+	if len(p.DeliveryId) > 0 {
+		labels[DeliveryIdLabel] = DeliveryLabelValue(p.DeliveryId)
+	}
+
+	if len(p.ConcurrencyKey) > 0 {
+		labels[ConcurrencyKeyLabel] = ConcurrencyLabelValue(p.PipelineName, p.ConcurrencyKey)
+	}
+
+	if p.PullRequest > 0 && len(p.Repo) > 0 {
+		labels[PullRequestLabel] = PullRequestLabelValue(p.Repo, p.PullRequest)
+	}
 
 	p.Labels = labels
 
@@ -113,19 +328,40 @@ func (p *PipelineRun) renderTemplate() (string, error) {
 	annotations["pipelinerun-created-at"] = time.Now().Format("2006-01-02_15-04-05.000")
 	annotations["pipeline-name"] = p.PipelineName
 
+	if len(p.ConcurrencyKey) > 0 {
+		annotations[ConcurrencyKeyLabel] = p.ConcurrencyKey
+	}
+
+	if len(p.Provider) > 0 {
+		annotations[ProviderAnnotation] = p.Provider
+	}
+
+	if len(p.Repo) > 0 {
+		annotations[RepoAnnotation] = p.Repo
+	}
+
+	if len(p.Sha) > 0 {
+		annotations[ShaAnnotation] = p.Sha
+	}
+
+	// Link the spans created by Tekton with the trace of the request
+	if len(spanContext) > 0 {
+		annotations[tracing.SpanContextAnnotation] = spanContext
+	}
+
 	p.Annotations = annotations
 
 	// Set params
 	params := make(map[string]string)
 
-	params["payloadBase64"] = base64.StdEncoding.EncodeToString(p.GitHubPayload) // Payload encoded in base64
-	params["event"] = p.GitHubEvent
+	params["payloadBase64"] = base64.StdEncoding.EncodeToString(p.Payload) // Payload encoded in base64
+	params["event"] = p.Event
 	params["pipelineRunId"] = p.ID
 
 	// Add extra params
 	for k, v := range p.ExtraParams {
-		// Don't provide pipeline and prefix as parameter (useful)
-		if strings.EqualFold(k, "pipeline") || strings.EqualFold(k, "prefix") {
+		// Don't provide pipeline, prefix and dryRun as parameter (useful)
+		if strings.EqualFold(k, "pipeline") || strings.EqualFold(k, "prefix") || strings.EqualFold(k, "dryRun") {
 			continue
 		}
 
@@ -139,7 +375,7 @@ func (p *PipelineRun) renderTemplate() (string, error) {
 	t := template.Must(template.New("base").Funcs(sprig.FuncMap()).Parse(workspacesTemplate))
 	t = template.Must(t.Parse(pipelineRunTemplate))
 
-	err := t.Execute(&tpl, p)
+	err = t.Execute(&tpl, p)
 
 	if err != nil {
 		return "", err
@@ -151,3 +387,33 @@ func (p *PipelineRun) renderTemplate() (string, error) {
 
 	return s, nil
 }
+
+// isCreatedBefore checks if a was created before b. The creation time has a resolution of
+// seconds, so the name breaks the ties
+func isCreatedBefore(a *unstructured.Unstructured, b *unstructured.Unstructured) bool {
+	ta := a.GetCreationTimestamp()
+	tb := b.GetCreationTimestamp()
+
+	if ta.Equal(&tb) {
+		return a.GetName() < b.GetName()
+	}
+
+	return ta.Before(&tb)
+}
+
+// isDone checks if the PipelineRun finished (the condition Succeeded is True or False)
+func isDone(obj *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+
+		if !ok || condition["type"] != "Succeeded" {
+			continue
+		}
+
+		return condition["status"] != "Unknown"
+	}
+
+	return false
+}
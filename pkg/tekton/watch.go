@@ -0,0 +1,22 @@
+package tekton
+
+import (
+	"context"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/k8s"
+)
+
+// WatchPipelineRuns calls handler when a PipelineRun created by the listener is added or updated,
+// until stopCh is closed
+func WatchPipelineRuns(stopCh <-chan struct{}, handler func(obj *unstructured.Unstructured)) error {
+	return k8s.WatchObjects(tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"),
+		PipelineRunIdLabel, stopCh, handler)
+}
+
+// PatchPipelineRun applies a JSON merge patch to the PipelineRun
+func PatchPipelineRun(ctx context.Context, name string, patch []byte) error {
+	return k8s.PatchObject(ctx, tektonApiGroup, tektonApiVersion, pipelineRunKind, os.Getenv("PIPELINES_NAMESPACE"), name, patch)
+}
@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Allowed exporters
+	ExporterNone   string = "none"
+	ExporterStdout string = "stdout"
+	ExporterOtlp   string = "otlp"
+
+	serviceName string = "custom-tekton-listener"
+	tracerName  string = "github.com/jaberchez/custom-tekton-listener"
+
+	// Annotation read by Tekton to link the spans of the PipelineRun with the parent trace
+	SpanContextAnnotation string = "tekton.dev/pipelinerunSpanContext"
+)
+
+// Init configures the global tracer provider with the exporter set in the environment variable
+// OTEL_TRACES_EXPORTER (none, stdout or otlp, default none). The otlp exporter is configured with
+// the standard environment variables (OTEL_EXPORTER_OTLP_ENDPOINT, ...). The returned function
+// flushes the pending spans and must be called before exiting
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	// The trace context is propagated even if the spans are not exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+
+	exporterName := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER"))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch exporterName {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	case ExporterOtlp:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("traces exporter %s not allowed (allowed values: %s, %s, %s)", exporterName,
+			ExporterNone, ExporterStdout, ExporterOtlp)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create traces exporter: %s", err.Error())
+	}
+
+	// Note: OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default name
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceNameKey.String(serviceName)))
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create traces resource: %s", err.Error())
+	}
+
+	res, err = resource.Merge(res, resource.Environment())
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create traces resource: %s", err.Error())
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// StartHttp starts the span of an http request, child of the trace context sent by the caller
+// in the headers (if any)
+func StartHttp(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.Path)))
+}
+
+// Start starts a span, child of the span in ctx (if any)
+func Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// End records err in span (if not nil) and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// SpanContextAnnotationValue returns the value of SpanContextAnnotation for the span in ctx, or
+// an empty string if there is not a valid span
+func SpanContextAnnotationValue(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+
+	carrier := propagation.MapCarrier{}
+
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	data, err := json.Marshal(carrier)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
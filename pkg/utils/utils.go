@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"net/http"
@@ -12,15 +15,28 @@ import (
 	//"github.com/rs/xid"
 	"github.com/sirupsen/logrus"
 	"github.com/teris-io/shortid"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/jaberchez/custom-tekton-listener/pkg/config"
 )
 
+// Fields added to the logs of a request
+const (
+	LogFieldRequestId  string = "pipelineRunId"
+	LogFieldDeliveryId string = "deliveryId"
+	LogFieldProvider   string = "provider"
+	LogFieldEvent      string = "event"
+	LogFieldPipeline   string = "pipeline"
+	LogFieldRepo       string = "repo"
+	LogFieldTraceId    string = "traceId"
+)
+
 var (
-	logger             *logrus.Logger
-	pipelineRunIdField string
+	logger *logrus.Logger
 )
 
+type logFieldsKey struct{}
+
 func init() {
 	logger = &logrus.Logger{
 		Out:   os.Stdout,
@@ -31,53 +47,104 @@ func init() {
 			FullTimestamp:   true,
 		},
 	}
+}
+
+// InitLog sets the level (debug, info, warning or error, default debug) and the format (text or
+// json, default text) of the logs
+func InitLog(level string, format string) error {
+	if len(level) > 0 {
+		l, err := logrus.ParseLevel(level)
+
+		if err != nil {
+			return fmt.Errorf("log level %s not allowed", level)
+		}
+
+		logger.SetLevel(l)
+	}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		// Default formatter
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
+	default:
+		return fmt.Errorf("log format %s not allowed (allowed values: text, json)", format)
+	}
 
-	pipelineRunIdField = ""
+	return nil
 }
 
 func Log(severity string, message string) {
+	logEntry(logrus.NewEntry(logger), severity, message)
+}
+
+// LogContext logs the message with the fields stored in ctx (see WithLogField) and the trace id
+// of the span in ctx (if any)
+func LogContext(ctx context.Context, severity string, message string) {
+	entry := logger.WithFields(logFieldsFromContext(ctx))
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		entry = entry.WithField(LogFieldTraceId, spanContext.TraceID().String())
+	}
+
+	logEntry(entry, severity, message)
+}
+
+// WithLogField returns a copy of ctx that adds the field to the logs written with LogContext.
+// Empty values are not added
+func WithLogField(ctx context.Context, key string, value string) context.Context {
+	if len(value) == 0 {
+		return ctx
+	}
+
+	fields := logrus.Fields{}
+
+	for k, v := range logFieldsFromContext(ctx) {
+		fields[k] = v
+	}
+
+	fields[key] = value
+
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+func logFieldsFromContext(ctx context.Context) logrus.Fields {
+	fields, ok := ctx.Value(logFieldsKey{}).(logrus.Fields)
+
+	if !ok {
+		return logrus.Fields{}
+	}
+
+	return fields
+}
+
+func logEntry(entry *logrus.Entry, severity string, message string) {
 	switch strings.ToLower(severity) {
+	case "debug":
+		entry.Debug(message)
 	case "info":
-		if len(pipelineRunIdField) > 0 {
-			logger.WithFields(logrus.Fields{
-				"pipelineRunId": pipelineRunIdField,
-			}).Info(message)
-
-		} else {
-			logger.Info(message)
-		}
+		entry.Info(message)
 	case "warning":
-		if len(pipelineRunIdField) > 0 {
-			logger.WithFields(logrus.Fields{
-				"pipelineRunId": pipelineRunIdField,
-			}).Warn(message)
-
-		} else {
-			logger.Warn(message)
-		}
+		entry.Warn(message)
 	case "error":
-		if len(pipelineRunIdField) > 0 {
-			logger.WithFields(logrus.Fields{
-				"pipelineRunId": pipelineRunIdField,
-			}).Error(message)
-
-		} else {
-			logger.Error(message)
-		}
+		entry.Error(message)
 	case "fatal":
-		if len(pipelineRunIdField) > 0 {
-			logger.WithFields(logrus.Fields{
-				"pipelineRunId": pipelineRunIdField,
-			}).Fatal(message)
-
-		} else {
-			logger.Fatal(message)
-		}
+		entry.Fatal(message)
 	}
 }
 
-func SetPipelineRunIdFieldLog(pipelineRunId string) {
-	pipelineRunIdField = pipelineRunId
+// SetLogOutput sets where the logs are written (stdout by default)
+func SetLogOutput(out io.Writer) {
+	logger.SetOutput(out)
+}
+
+// DetachContext returns a context with the values of ctx (fields of the logs, span, ...) that is
+// not canceled with ctx. It is used to continue processing a request in background after the
+// response is sent
+func DetachContext(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
 }
 
 func GetIpFromRequest(r *http.Request) (string, error) {
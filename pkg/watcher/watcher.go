@@ -0,0 +1,227 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/tekton"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+// States of a PipelineRun
+const (
+	StatePending   string = "pending" // Waiting for a free slot of maxConcurrent
+	StateRunning   string = "running"
+	StateSucceeded string = "succeeded"
+	StateFailed    string = "failed"
+	StateCancelled string = "cancelled"
+)
+
+// The PipelineRuns that finished longer ago when the watcher starts are not notified, so the
+// PipelineRuns created before enabling a subscriber are not notified all at once
+const maxAgeAtStartup time.Duration = time.Hour
+
+// Run is a PipelineRun created by the listener
+type Run struct {
+	Name      string
+	Namespace string
+	ID        string
+	Pipeline  string
+	Provider  string
+	Repo      string
+	Sha       string
+
+	State   string
+	Reason  string // Reason of the condition Succeeded, e.g. Failed or PipelineRunTimeout
+	Message string
+
+	StartTime      *time.Time
+	CompletionTime *time.Time
+}
+
+// IsDone checks if the PipelineRun finished
+func (r *Run) IsDone() bool {
+	return r.State == StateSucceeded || r.State == StateFailed || r.State == StateCancelled
+}
+
+// Subscriber is notified when the state of a PipelineRun changes
+type Subscriber interface {
+	// Name of the subscriber, it must be a valid annotation key. The last state notified is saved
+	// in the annotation <name>-notified of the PipelineRun
+	Name() string
+
+	// Wants checks if the subscriber has to be notified of the run in its current state
+	Wants(run *Run) bool
+
+	// Notify is called once per PipelineRun and state, even if there are several instances of
+	// the listener. An error is only logged
+	Notify(ctx context.Context, run *Run) error
+}
+
+// Watcher notifies the subscribers of the changes of the PipelineRuns created by the listener
+type Watcher struct {
+	subscribers []Subscriber
+	startedAt   time.Time
+}
+
+func New() *Watcher {
+	return &Watcher{}
+}
+
+// Subscribe adds a subscriber. It must be called before Run
+func (w *Watcher) Subscribe(s Subscriber) {
+	w.subscribers = append(w.subscribers, s)
+}
+
+// Run watches the PipelineRuns until stopCh is closed
+//
+// Note: The notifications are sent one at a time, in the order of the changes
+func (w *Watcher) Run(stopCh <-chan struct{}) error {
+	w.startedAt = time.Now()
+
+	return tekton.WatchPipelineRuns(stopCh, w.handle)
+}
+
+func (w *Watcher) handle(obj *unstructured.Unstructured) {
+	run := newRun(obj)
+
+	if run.IsDone() && run.CompletionTime != nil && run.CompletionTime.Before(w.startedAt.Add(-maxAgeAtStartup)) {
+		return
+	}
+
+	ctx := utils.WithLogField(context.Background(), utils.LogFieldRequestId, run.ID)
+	ctx = utils.WithLogField(ctx, utils.LogFieldPipeline, run.Pipeline)
+
+	annotations := obj.GetAnnotations()
+	claim := make(map[string]string)
+
+	var subscribers []Subscriber
+
+	for _, s := range w.subscribers {
+		key := notifiedAnnotation(s)
+
+		if annotations[key] == run.State || !s.Wants(run) {
+			continue
+		}
+
+		claim[key] = run.State
+		subscribers = append(subscribers, s)
+	}
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	// Claim the notifications before sending them
+	//
+	// Note: The resourceVersion makes the patch fail if the PipelineRun changed, e.g. another
+	// instance claimed them or the state changed (the new version is notified too)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": obj.GetResourceVersion(),
+			"annotations":     claim,
+		},
+	})
+
+	if err != nil {
+		utils.LogContext(ctx, "ERROR", err.Error())
+		return
+	}
+
+	err = tekton.PatchPipelineRun(ctx, run.Name, patch)
+
+	if err != nil {
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			return
+		}
+
+		utils.LogContext(ctx, "ERROR", fmt.Sprintf("unable to claim notifications of pipelinerun %s: %s", run.Name, err.Error()))
+
+		return
+	}
+
+	for _, s := range subscribers {
+		err = s.Notify(ctx, run)
+
+		if err != nil {
+			utils.LogContext(ctx, "ERROR", fmt.Sprintf("%s: unable to notify state %s of pipelinerun %s: %s", s.Name(), run.State,
+				run.Name, err.Error()))
+		}
+	}
+}
+
+func notifiedAnnotation(s Subscriber) string {
+	return fmt.Sprintf("%s-notified", s.Name())
+}
+
+// newRun returns the fields of the PipelineRun
+func newRun(obj *unstructured.Unstructured) *Run {
+	labels := obj.GetLabels()
+	annotations := obj.GetAnnotations()
+
+	run := &Run{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		ID:        labels[tekton.PipelineRunIdLabel],
+		Pipeline:  labels[tekton.PipelineNameLabel],
+		Provider:  annotations[tekton.ProviderAnnotation],
+		Repo:      annotations[tekton.RepoAnnotation],
+		Sha:       annotations[tekton.ShaAnnotation],
+		State:     StateRunning,
+	}
+
+	run.StartTime = nestedTime(obj, "status", "startTime")
+	run.CompletionTime = nestedTime(obj, "status", "completionTime")
+
+	if specStatus, _, _ := unstructured.NestedString(obj.Object, "spec", "status"); specStatus == tekton.PipelineRunPending {
+		run.State = StatePending
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+
+		if !ok || condition["type"] != "Succeeded" {
+			continue
+		}
+
+		run.Reason, _ = condition["reason"].(string)
+		run.Message, _ = condition["message"].(string)
+
+		switch condition["status"] {
+		case "True":
+			run.State = StateSucceeded
+		case "False":
+			run.State = StateFailed
+
+			switch run.Reason {
+			case "Cancelled", "PipelineRunCancelled", "CancelledRunFinally", "StoppedRunFinally":
+				run.State = StateCancelled
+			}
+		}
+	}
+
+	return run
+}
+
+func nestedTime(obj *unstructured.Unstructured, fields ...string) *time.Time {
+	value, found, _ := unstructured.NestedString(obj.Object, fields...)
+
+	if !found {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return nil
+	}
+
+	return &t
+}
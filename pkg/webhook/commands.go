@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tekton"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+// Commands that can be written in the comments of pull requests
+const (
+	CommandRetest string = "retest"
+	CommandCancel string = "cancel"
+)
+
+// CommentCommand is a command written in a comment of a pull request, e.g. "/retest build"
+type CommentCommand struct {
+	Name        string // CommandRetest or CommandCancel
+	Pipeline    string // Empty applies the command to all pipelines
+	Author      string
+	Association string // Association of the author with the repository, e.g. OWNER
+	Repo        string
+	PullRequest int64
+}
+
+// CommentCommandProvider is implemented by the providers that support commands in the comments
+// of pull requests
+type CommentCommandProvider interface {
+	// CommentCommand returns the command of the event, or nil if the event is not a new comment
+	// of a pull request or the comment does not have a command
+	CommentCommand(event string, payload []byte) *CommentCommand
+
+	// PullRequestEvent returns the event of the pull request of the command, processed instead of
+	// the comment to run the pipeline again. payload is the payload of the comment
+	PullRequestEvent(ctx context.Context, command *CommentCommand, payload []byte) (string, []byte, error)
+
+	// EventHeader returns the header with the type of event, set to the event of the pull request
+	// to check the when conditions of headers
+	EventHeader() string
+}
+
+// ParseCommentCommand returns the first command of the comment (a line that starts with
+// /retest or /cancel, optionally followed by the name of a pipeline), or nil if there is none
+func ParseCommentCommand(body string) *CommentCommand {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+
+		if name != CommandRetest && name != CommandCancel {
+			continue
+		}
+
+		command := &CommentCommand{Name: name}
+
+		if len(fields) > 1 {
+			command.Pipeline = strings.ToLower(fields[1])
+		}
+
+		return command
+	}
+
+	return nil
+}
+
+// handleCommand runs the command for the pipeline of the request. It returns false if the
+// request has to continue with the event of the pull request (retest)
+func (req *Request) handleCommand(ctx context.Context, result *Result, command *CommentCommand,
+	pipelineConfig *config.Pipeline) bool {
+	if len(command.Pipeline) > 0 && command.Pipeline != pipelineConfig.Name {
+		result.finish(ctx, http.StatusOK, "INFO", fmt.Sprintf("command /%s for pipeline %s, ignored", command.Name, command.Pipeline))
+		return true
+	}
+
+	if !config.IsCommentAssociationAllowed(pipelineConfig, command.Association) {
+		result.finish(ctx, http.StatusForbidden, "WARNING", fmt.Sprintf("%s (%s) is not allowed to run /%s", command.Author,
+			command.Association, command.Name))
+
+		return true
+	}
+
+	if req.DryRun && command.Name == CommandCancel {
+		result.finish(ctx, http.StatusOK, "INFO", fmt.Sprintf("dry run, pipelineruns of pull request #%d would be cancelled",
+			command.PullRequest))
+
+		return true
+	}
+
+	if command.Name == CommandCancel {
+		cancelled, err := tekton.CancelPullRequest(ctx, pipelineConfig.Name, command.Repo, command.PullRequest)
+
+		if len(cancelled) > 0 {
+			metrics.PipelineRunsCancelled(pipelineConfig.Name, len(cancelled))
+		}
+
+		if err != nil {
+			result.finish(ctx, http.StatusInternalServerError, "ERROR", err.Error())
+			return true
+		}
+
+		if len(cancelled) == 0 {
+			result.finish(ctx, http.StatusOK, "INFO", fmt.Sprintf("/cancel by %s, no pipelineruns running for pull request #%d",
+				command.Author, command.PullRequest))
+
+			return true
+		}
+
+		result.finish(ctx, http.StatusOK, "INFO", fmt.Sprintf("/cancel by %s, cancelled pipelineruns of pull request #%d: %s",
+			command.Author, command.PullRequest, strings.Join(cancelled, ", ")))
+
+		return true
+	}
+
+	utils.LogContext(ctx, "INFO", fmt.Sprintf("/retest by %s of pull request #%d", command.Author, command.PullRequest))
+
+	return false
+}
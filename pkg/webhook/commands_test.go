@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+)
+
+// commandProvider is a provider whose comments are the payload, the event is in the header
+// X-Test-Event
+type commandProvider struct{}
+
+func (p *commandProvider) Name() string {
+	return "test"
+}
+
+func (p *commandProvider) Event(r *http.Request) (string, error) {
+	return r.Header.Get(p.EventHeader()), nil
+}
+
+func (p *commandProvider) DeliveryId(r *http.Request) string {
+	return ""
+}
+
+func (p *commandProvider) IsPing(event string) bool {
+	return false
+}
+
+func (p *commandProvider) CheckSourceIp(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+func (p *commandProvider) VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error) {
+	return true, nil
+}
+
+func (p *commandProvider) Normalize(r *http.Request, event string, payload []byte) (*Event, error) {
+	return &Event{Type: event, Payload: payload, Repo: "owner/repo"}, nil
+}
+
+func (p *commandProvider) CommentCommand(event string, payload []byte) *CommentCommand {
+	if event != "issue_comment" {
+		return nil
+	}
+
+	command := ParseCommentCommand(string(payload))
+
+	if command != nil {
+		command.Author, command.Association = "octocat", "OWNER"
+		command.Repo, command.PullRequest = "owner/repo", 1
+	}
+
+	return command
+}
+
+func (p *commandProvider) PullRequestEvent(ctx context.Context, command *CommentCommand, payload []byte) (string, []byte, error) {
+	return "pull_request", []byte(`{"action": "synchronize", "number": 1}`), nil
+}
+
+func (p *commandProvider) EventHeader() string {
+	return "X-Test-Event"
+}
+
+func TestParseCommentCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *CommentCommand
+	}{
+		{"retest", "/retest", &CommentCommand{Name: CommandRetest}},
+		{"cancel", "/cancel", &CommentCommand{Name: CommandCancel}},
+		{"with pipeline", "/retest Build", &CommentCommand{Name: CommandRetest, Pipeline: "build"}},
+		{"extra words", "/cancel build please", &CommentCommand{Name: CommandCancel, Pipeline: "build"}},
+		{"upper case", "/RETEST", &CommentCommand{Name: CommandRetest}},
+		{"indented", "  \t/retest", &CommentCommand{Name: CommandRetest}},
+		{"after text", "Flaky test\n\n/retest\r\nthanks", &CommentCommand{Name: CommandRetest}},
+		{"first command", "/cancel build\n/retest deploy", &CommentCommand{Name: CommandCancel, Pipeline: "build"}},
+		{"unknown command skipped", "/lgtm\n/retest", &CommentCommand{Name: CommandRetest}},
+		{"empty", "", nil},
+		{"no command", "LGTM", nil},
+		{"in the middle of a line", "please /retest", nil},
+		{"unknown command", "/approve", nil},
+		{"prefix of a command", "/retesting", nil},
+		{"only slash", "/", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseCommentCommand(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCommentCommand(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleRequestRetest(t *testing.T) {
+	err := config.LoadConfigFromData([]byte(`
+pipelines:
+- name: pull-request
+  when:
+  - kind: header
+    keys:
+    - X-Test-Event
+    values:
+    - operator: equal
+      data: pull_request
+- name: comment
+  when:
+  - kind: header
+    keys:
+    - X-Test-Event
+    values:
+    - operator: equal
+      data: issue_comment
+`))
+
+	if err != nil {
+		t.Fatalf("LoadConfigFromData() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		pipeline string
+		comment  string
+		launched bool
+	}{
+		{"retest checks the event of the pull request", "pull-request", "/retest", true},
+		{"retest does not check the event of the comment", "comment", "/retest", false},
+		{"comment without command", "pull-request", "LGTM", false},
+		{"comment without command checks the event of the comment", "comment", "LGTM", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/test?pipeline="+tt.pipeline+"&prefix=test",
+				strings.NewReader(tt.comment))
+
+			r.Header.Set("X-Test-Event", "issue_comment")
+
+			req := &Request{
+				ID:          "abc",
+				Provider:    &commandProvider{},
+				HttpRequest: r,
+				Payload:     []byte(tt.comment),
+				Event:       "issue_comment",
+				DryRun:      true,
+			}
+
+			result := req.HandleRequest(context.Background())
+
+			if result.Launched != tt.launched {
+				t.Errorf("HandleRequest() launched = %v, want %v: %s", result.Launched, tt.launched, result.Message)
+			}
+
+			// The request of the comment is not modified
+			if got := r.Header.Get("X-Test-Event"); got != "issue_comment" {
+				t.Errorf("header of the request changed to %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+)
+
+// concurrencyKeyData are the fields available in the template of the concurrency key
+type concurrencyKeyData struct {
+	Pipeline string
+	Event    string
+	Repo     string
+	RepoUrl  string
+	Ref      string
+	Sha      string
+	Params   map[string]string
+}
+
+// renderConcurrencyKey executes the template of the concurrency key. An empty key (e.g. a field
+// not provided by the provider) disables the concurrency policy for the request
+func renderConcurrencyKey(keyTemplate string, pipelineName string, event *Event, params map[string]string) (string, error) {
+	t, err := template.New("key").Funcs(sprig.TxtFuncMap()).Parse(keyTemplate)
+
+	if err != nil {
+		return "", err
+	}
+
+	data := &concurrencyKeyData{
+		Pipeline: pipelineName,
+		Event:    event.Type,
+		Repo:     event.Repo,
+		RepoUrl:  event.RepoUrl,
+		Ref:      event.Ref,
+		Sha:      event.Sha,
+		Params:   params,
+	}
+
+	var key bytes.Buffer
+
+	err = t.Execute(&key, data)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(key.String()), nil
+}
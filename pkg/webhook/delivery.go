@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultDeliveryTTL time.Duration = time.Hour
+
+// deliveryCache stores the deliveries that launched a PipelineRun during ttl. It avoids asking the
+// Kubernetes API for most redeliveries, and the concurrent redeliveries to the same instance
+type deliveryCache struct {
+	mutex      sync.Mutex
+	ttl        time.Duration
+	deliveries map[string]time.Time
+	lastPurge  time.Time
+}
+
+var deliveries = &deliveryCache{
+	ttl:        defaultDeliveryTTL,
+	deliveries: make(map[string]time.Time),
+}
+
+// SetDeliveryTTL sets how long the deliveries are remembered in memory (1 hour by default).
+// Older deliveries are looked up in the PipelineRuns
+func SetDeliveryTTL(ttl time.Duration) {
+	deliveries.mutex.Lock()
+	defer deliveries.mutex.Unlock()
+
+	deliveries.ttl = ttl
+}
+
+// reserve stores the delivery and returns true, or false if it is already stored
+func (c *deliveryCache) reserve(deliveryId string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	// Remove the expired deliveries from time to time
+	if now.Sub(c.lastPurge) > c.ttl {
+		for k, t := range c.deliveries {
+			if now.Sub(t) > c.ttl {
+				delete(c.deliveries, k)
+			}
+		}
+
+		c.lastPurge = now
+	}
+
+	if t, ok := c.deliveries[deliveryId]; ok && now.Sub(t) <= c.ttl {
+		return false
+	}
+
+	c.deliveries[deliveryId] = now
+
+	return true
+}
+
+// release removes the delivery, e.g. if the PipelineRun could not be created
+func (c *deliveryCache) release(deliveryId string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.deliveries, deliveryId)
+}
@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestDeliveryCache() *deliveryCache {
+	return &deliveryCache{
+		ttl:        time.Hour,
+		deliveries: make(map[string]time.Time),
+	}
+}
+
+func TestDeliveryCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *deliveryCache)
+		want  bool
+	}{
+		{
+			name:  "new delivery",
+			setup: func(c *deliveryCache) {},
+			want:  true,
+		},
+		{
+			name:  "redelivery",
+			setup: func(c *deliveryCache) { c.reserve("id") },
+			want:  false,
+		},
+		{
+			name: "redelivery after release",
+			setup: func(c *deliveryCache) {
+				c.reserve("id")
+				c.release("id")
+			},
+			want: true,
+		},
+		{
+			name:  "redelivery after ttl",
+			setup: func(c *deliveryCache) { c.deliveries["id"] = time.Now().Add(-2 * time.Hour) },
+			want:  true,
+		},
+		{
+			name:  "other delivery",
+			setup: func(c *deliveryCache) { c.reserve("other") },
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestDeliveryCache()
+
+			tt.setup(c)
+
+			if got := c.reserve("id"); got != tt.want {
+				t.Errorf("reserve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliveryCachePurge(t *testing.T) {
+	c := newTestDeliveryCache()
+
+	c.deliveries["expired"] = time.Now().Add(-2 * time.Hour)
+	c.deliveries["recent"] = time.Now()
+	c.lastPurge = time.Now().Add(-2 * time.Hour)
+
+	c.reserve("new")
+
+	if _, ok := c.deliveries["expired"]; ok {
+		t.Errorf("expired delivery not purged")
+	}
+
+	for _, id := range []string{"recent", "new"} {
+		if _, ok := c.deliveries[id]; !ok {
+			t.Errorf("delivery %s purged", id)
+		}
+	}
+}
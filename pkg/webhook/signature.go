@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// IsValidHmac checks if signature (hexadecimal) is the HMAC of payload
+func IsValidHmac(signature string, hashFunc func() hash.Hash, secret string, payload []byte) (bool, error) {
+	gotSum, err := hex.DecodeString(signature)
+
+	if err != nil {
+		return false, fmt.Errorf("malformed signature: %s", err)
+	}
+
+	mac := hmac.New(hashFunc, []byte(secret))
+
+	if _, err := mac.Write(payload); err != nil {
+		return false, fmt.Errorf("cannot compute the HMAC for request: %s", err)
+	}
+
+	// Constant time comparison
+	return hmac.Equal(gotSum, mac.Sum(nil)), nil
+}
@@ -0,0 +1,482 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/metrics"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tekton"
+	"github.com/jaberchez/custom-tekton-listener/pkg/tracing"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+// Provider is implemented by each SCM (GitHub, GitLab, ...) to plug it into the
+// common request flow
+type Provider interface {
+	// Name of the provider, the endpoint is /api/v1/<name>
+	Name() string
+
+	// Event returns the type of event from the http request
+	Event(r *http.Request) (string, error)
+
+	// DeliveryId returns the unique id that the provider sets to each delivery (redeliveries
+	// keep the same id), or an empty string if it is not provided
+	DeliveryId(r *http.Request) string
+
+	// IsPing checks if the event is only sent to test the webhook
+	IsPing(event string) bool
+
+	// CheckSourceIp checks if the request comes from an allowed ip
+	CheckSourceIp(r *http.Request) (bool, error)
+
+	// VerifySignature checks the request against the secret configured for the pipeline.
+	// It returns true if there is no secret configured
+	VerifySignature(r *http.Request, payload []byte, pipeline *config.Pipeline) (bool, error)
+
+	// Normalize returns the payload to provide to the PipelineRun and the common
+	// fields of the event
+	Normalize(r *http.Request, event string, payload []byte) (*Event, error)
+}
+
+// Event stores the fields common to all providers
+type Event struct {
+	Type    string
+	Payload []byte
+	Repo    string // Full name, e.g. owner/repo
+	RepoUrl string // Clone url
+	Ref     string // e.g. refs/heads/main
+	Sha     string // Head commit
+
+	// Number of the pull request, 0 if the event is not related to a pull request
+	PullRequest int64
+
+	// Attributes of the event that can be checked in when clauses (only CloudEvents)
+	Attributes map[string]string
+
+	// Params provided to the PipelineRun along with the extra params
+	Params map[string]string
+}
+
+type Request struct {
+	ID          string
+	Provider    Provider
+	HttpRequest *http.Request
+	Payload     []byte
+	Event       string
+	DeliveryId  string
+
+	// If DryRun is true the PipelineRun is rendered but not created
+	DryRun bool
+
+	// SignatureVerified is true if the signature was verified when the request was received (the
+	// dead letters replayed), so it is not verified again
+	SignatureVerified bool
+}
+
+// Result is the outcome of a request
+type Result struct {
+	ID              string              `yaml:"id" json:"id"`
+	Status          int                 `yaml:"status" json:"status"`
+	DryRun          bool                `yaml:"dryRun" json:"dryRun"`
+	Pipeline        string              `yaml:"pipeline,omitempty" json:"pipeline,omitempty"`
+	PipelineRunName string              `yaml:"pipelineRunName,omitempty" json:"pipelineRunName,omitempty"`
+	When            []config.WhenResult `yaml:"when,omitempty" json:"when,omitempty"`
+	Launched        bool                `yaml:"launched" json:"launched"` // In dry run, whether it would be launched
+	Pending         bool                `yaml:"pending,omitempty" json:"pending,omitempty"`
+	Message         string              `yaml:"message" json:"message"`
+	PipelineRun     string              `yaml:"pipelineRun,omitempty" json:"pipelineRun,omitempty"` // Only in dry run
+
+	// Failed is true if the request was rejected or failed (e.g. wrong signature or the PipelineRun
+	// could not be created), not if it was skipped on purpose (e.g. when conditions not met)
+	Failed bool `yaml:"-" json:"-"`
+
+	// SignatureVerified is true if the signature of the request was verified
+	SignatureVerified bool `yaml:"-" json:"-"`
+}
+
+// finish logs the message and stores it in the result along with the http status that
+// describes the outcome (used in synchronous mode)
+func (res *Result) finish(ctx context.Context, status int, severity string, message string) *Result {
+	utils.LogContext(ctx, severity, message)
+
+	res.Status = status
+	res.Message = message
+	res.Failed = severity == "ERROR"
+
+	return res
+}
+
+// HandleRequest processes the request. ctx carries the span of the http request, the rest of
+// spans are created as children. The logs are written with the fields of ctx along with the
+// fields of the request
+func (req *Request) HandleRequest(ctx context.Context) *Result {
+	ctx = utils.WithLogField(ctx, utils.LogFieldRequestId, req.ID)
+	ctx = utils.WithLogField(ctx, utils.LogFieldProvider, req.Provider.Name())
+	ctx = utils.WithLogField(ctx, utils.LogFieldEvent, req.Event)
+	ctx = utils.WithLogField(ctx, utils.LogFieldDeliveryId, req.DeliveryId)
+
+	result := &Result{
+		ID:     req.ID,
+		DryRun: req.DryRun,
+	}
+
+	// Get query parameters
+	queryParams := req.HttpRequest.URL.Query()
+
+	if len(queryParams) == 0 {
+		return result.finish(ctx, http.StatusUnprocessableEntity, "ERROR", "found empty parameters in http query request")
+	}
+
+	var pipelineName, prefix string
+
+	// Check if pipeline param exists in query string
+	//
+	// Note: "pipeline" and "prefix" are mandatory parameters
+	for i, item := range []string{"pipeline", "prefix"} {
+		paramFound, paramValue := getQueryParam(item, queryParams)
+
+		if !paramFound {
+			return result.finish(ctx, http.StatusUnprocessableEntity, "ERROR", fmt.Sprintf("%s param not found in query request", strings.ToUpper(item)))
+		}
+
+		if len(paramValue) == 0 {
+			return result.finish(ctx, http.StatusUnprocessableEntity, "ERROR", fmt.Sprintf("found empty value in http query param %s", strings.ToUpper(item)))
+		}
+
+		if i == 0 {
+			pipelineName = strings.ToLower(paramValue)
+		} else {
+			// Remove the last - or _ (if exists)
+			if paramValue[len(paramValue)-1:] == "-" || paramValue[len(paramValue)-1:] == "_" {
+				paramValue = paramValue[0 : len(paramValue)-1]
+			}
+
+			prefix = strings.ToLower(paramValue)
+		}
+	}
+
+	result.Pipeline = pipelineName
+
+	ctx = utils.WithLogField(ctx, utils.LogFieldPipeline, pipelineName)
+
+	// Get the configuration for this particuar pipeline
+	pipelineConfig := config.GetPipeline(pipelineName)
+
+	if pipelineConfig == nil {
+		return result.finish(ctx, http.StatusNotFound, "ERROR", fmt.Sprintf("pipeline %s not found in configuration", pipelineName))
+	}
+
+	// Check if this webhook is a secure webhook
+	//
+	// Note: The dead letters are saved without the credentials, their signature was verified
+	//       when they were received
+	if !req.SignatureVerified {
+		_, span := tracing.Start(ctx, "verify signature")
+
+		ok, err := req.Provider.VerifySignature(req.HttpRequest, req.Payload, pipelineConfig)
+
+		if err == nil && !ok {
+			tracing.End(span, errors.New("wrong webhook signature"))
+		} else {
+			tracing.End(span, err)
+		}
+
+		if err != nil || !ok {
+			metrics.SignatureFailure(req.Provider.Name(), pipelineName)
+
+			if err != nil {
+				return result.finish(ctx, http.StatusUnauthorized, "ERROR", err.Error())
+			}
+
+			return result.finish(ctx, http.StatusUnauthorized, "ERROR", "wrong webhook signature")
+		}
+
+		// Note: The event is only recorded after verifying the signature, it is sent by the caller.
+		//       Without a secret, the unknown events are recorded as unknown
+		if !req.DryRun {
+			metrics.WebhookReceived(req.Provider.Name(), req.Event, config.HasSecret(req.Provider.Name(), pipelineConfig))
+		}
+	}
+
+	result.SignatureVerified = true
+
+	var err error
+
+	eventType := req.Event
+	payload := req.Payload
+	httpRequest := req.HttpRequest
+
+	// Commands in the comments of pull requests (e.g. /retest)
+	if commandProvider, ok := req.Provider.(CommentCommandProvider); ok {
+		if command := commandProvider.CommentCommand(req.Event, req.Payload); command != nil {
+			ctx = utils.WithLogField(ctx, utils.LogFieldRepo, command.Repo)
+
+			done := req.handleCommand(ctx, result, command, pipelineConfig)
+
+			if done {
+				return result
+			}
+
+			// Note: /retest continues with the event of the pull request
+			eventType, payload, err = commandProvider.PullRequestEvent(ctx, command, req.Payload)
+
+			if err != nil {
+				return result.finish(ctx, http.StatusInternalServerError, "ERROR", fmt.Sprintf("unable to get pull request to retest: %s", err.Error()))
+			}
+
+			// The headers of the request are the ones of the comment, the when conditions of
+			// headers are checked with the event of the pull request
+			httpRequest = req.HttpRequest.Clone(ctx)
+			httpRequest.Header.Set(commandProvider.EventHeader(), eventType)
+		}
+	}
+
+	// Get the common fields of the event
+	//
+	// Note: It has to be done after checking the signature, which is computed with the
+	//       original payload
+	event, err := req.Provider.Normalize(httpRequest, eventType, payload)
+
+	if err != nil {
+		return result.finish(ctx, http.StatusUnprocessableEntity, "ERROR", err.Error())
+	}
+
+	ctx = utils.WithLogField(ctx, utils.LogFieldRepo, event.Repo)
+
+	// Check if we should run a pipeline
+	//
+	// Note: All when conditions are evaluated to return the result of each one
+	_, span := tracing.Start(ctx, "evaluate when")
+
+	whenResults, pass, err := config.EvaluateWhenConditions(pipelineConfig.When, queryParams, httpRequest,
+		event.Payload, event.Attributes)
+
+	span.SetAttributes(attribute.Bool("when.matched", pass))
+	tracing.End(span, err)
+
+	result.When = whenResults
+
+	if !req.DryRun {
+		recordWhenMetric(pipelineName, pass, err)
+	}
+
+	if err != nil {
+		return result.finish(ctx, http.StatusUnprocessableEntity, "ERROR", err.Error())
+	}
+
+	if !pass {
+		return result.finish(ctx, http.StatusUnprocessableEntity, "INFO", "pipelinerun is not launched because does not meet the when conditions")
+	}
+
+	// Create PipelineRun
+	pipelineRun := &tekton.PipelineRun{
+		ID:            req.ID,
+		PipelineName:  pipelineName,
+		Prefix:        prefix,
+		Payload:       event.Payload,
+		Event:         event.Type,
+		Workspaces:    pipelineConfig.Workspaces,
+		Resources:     pipelineConfig.Resources,
+		DeliveryId:    req.DeliveryId,
+		MaxConcurrent: pipelineConfig.MaxConcurrent,
+		Provider:      req.Provider.Name(),
+		Repo:          event.Repo,
+		Sha:           event.Sha,
+		PullRequest:   event.PullRequest,
+	}
+
+	// Service account
+	// Note: If service account is configured en global and particular pipeline, the service account
+	//       of pipeline takes precedence
+	serviceAccount := config.GetGlobalServiceAccount()
+
+	if len(pipelineConfig.ServiceAccount) > 0 {
+		serviceAccount = pipelineConfig.ServiceAccount
+	}
+
+	if len(serviceAccount) > 0 {
+		pipelineRun.ServiceAccount = serviceAccount
+	}
+
+	// Set extra params
+	//
+	// Notes: - Extra params come from the query string, global and particular params from configmap
+	//          and the params of the event
+	//        - The order is global custom data, particular custom data, event params and query http params
+	extraParams := config.GetGlobalExtraParams()
+
+	// Note: If a key exists in global extra params is overwriten
+	for _, item := range pipelineConfig.ExtraParams {
+		extraParams[item.Name] = item.Value
+	}
+
+	for k, v := range event.Params {
+		extraParams[k] = v
+	}
+
+	for k, v := range queryParams {
+		// Store the parameters as they have been set
+		extraParams[k] = v[0]
+	}
+
+	pipelineRun.ExtraParams = extraParams
+
+	// Concurrency key of the PipelineRun, the older ones with the same key are superseded
+	//
+	// Note: If the key cannot be rendered the PipelineRun is launched anyway
+	if pipelineConfig.Concurrency != nil {
+		key, err := renderConcurrencyKey(pipelineConfig.Concurrency.Key, pipelineName, event, extraParams)
+
+		if err != nil {
+			utils.LogContext(ctx, "WARNING", fmt.Sprintf("unable to render the concurrency key: %s", err.Error()))
+		}
+
+		pipelineRun.ConcurrencyKey = key
+	}
+
+	result.PipelineRunName = pipelineRun.Name()
+
+	if req.DryRun {
+		manifest, err := pipelineRun.Render(ctx)
+
+		if err != nil {
+			return result.finish(ctx, http.StatusInternalServerError, "ERROR", err.Error())
+		}
+
+		result.PipelineRun = manifest
+		result.Launched = true
+
+		return result.finish(ctx, http.StatusOK, "INFO", "dry run, pipelinerun rendered but not launched")
+	}
+
+	// Skip the deliveries already processed (e.g. redelivered by the provider)
+	//
+	// Note: The delivery is reserved before creating the PipelineRun to also skip the concurrent
+	// redeliveries to this instance
+	checkDelivery := len(req.DeliveryId) > 0 && !pipelineConfig.AllowsRedelivery()
+
+	if checkDelivery {
+		processed, err := isDeliveryProcessed(ctx, req.DeliveryId)
+
+		if err != nil {
+			// Note: The request is not rejected, a duplicated PipelineRun is better than a lost one
+			utils.LogContext(ctx, "WARNING", fmt.Sprintf("unable to check if the delivery was already processed: %s", err.Error()))
+		}
+
+		if processed {
+			metrics.RedeliverySkipped(pipelineName)
+
+			return result.finish(ctx, http.StatusOK, "INFO", fmt.Sprintf("delivery %s already processed, pipelinerun is not launched", req.DeliveryId))
+		}
+	}
+
+	err = pipelineRun.Start(ctx)
+
+	if err != nil {
+		if checkDelivery {
+			deliveries.release(req.DeliveryId)
+		}
+
+		metrics.PipelineRunFailed(pipelineName)
+
+		return result.finish(ctx, http.StatusInternalServerError, "ERROR", err.Error())
+	}
+
+	metrics.PipelineRunCreated(pipelineName)
+
+	result.Launched = true
+	result.Pending = pipelineRun.Pending
+
+	if pipelineConfig.Concurrency != nil {
+		superseded, err := pipelineRun.SupersedeOlder(ctx, pipelineConfig.Concurrency.GetPolicy())
+
+		if len(superseded) > 0 {
+			metrics.PipelineRunsSuperseded(pipelineName, len(superseded))
+
+			utils.LogContext(ctx, "INFO", fmt.Sprintf("superseded pipelineruns (%s): %s", pipelineConfig.Concurrency.GetPolicy(),
+				strings.Join(superseded, ", ")))
+		}
+
+		if err != nil {
+			utils.LogContext(ctx, "WARNING", err.Error())
+		}
+	}
+
+	if pipelineRun.Pending {
+		return result.finish(ctx, http.StatusCreated, "INFO", fmt.Sprintf("ok launched pipelinerun as pending, pipeline %s reached maxConcurrent (%d)",
+			pipelineName, pipelineConfig.MaxConcurrent))
+	}
+
+	return result.finish(ctx, http.StatusCreated, "INFO", "ok launched pipelinerun")
+}
+
+// isDeliveryProcessed checks if a PipelineRun was created for the delivery, first in memory and
+// then in the cluster (after a restart or if it was processed by another instance). If not, the
+// delivery is reserved
+func isDeliveryProcessed(ctx context.Context, deliveryId string) (bool, error) {
+	if !deliveries.reserve(deliveryId) {
+		return true, nil
+	}
+
+	exists, err := tekton.DeliveryExists(ctx, deliveryId)
+
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// recordWhenMetric records the outcome of the when conditions
+func recordWhenMetric(pipelineName string, pass bool, err error) {
+	switch {
+	case err != nil:
+		metrics.WhenEvaluated(pipelineName, metrics.WhenError)
+	case pass:
+		metrics.WhenEvaluated(pipelineName, metrics.WhenMatched)
+	default:
+		metrics.WhenEvaluated(pipelineName, metrics.WhenNotMatched)
+	}
+}
+
+// GetPipelineFromRequest returns the configuration of the pipeline set in the query param
+// pipeline, or nil if it is not found
+func GetPipelineFromRequest(r *http.Request) *config.Pipeline {
+	paramFound, paramValue := getQueryParam("pipeline", r.URL.Query())
+
+	if !paramFound || len(paramValue) == 0 {
+		return nil
+	}
+
+	return config.GetPipeline(paramValue)
+}
+
+func getQueryParam(name string, params url.Values) (bool, string) {
+	var paramValues []string
+	var paramFound bool
+	var paramValue string
+
+	for k, val := range params {
+		keyTmp := strings.ToLower(k)
+
+		if keyTmp == name {
+			paramFound = true
+			paramValues = val
+			break
+		}
+	}
+
+	if len(paramValues) > 0 {
+		// Return the first value
+		paramValue = paramValues[0]
+	}
+
+	return paramFound, paramValue
+}
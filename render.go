@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+	"github.com/jaberchez/custom-tekton-listener/pkg/webhook"
+)
+
+// headerFlags stores the repeated flag -header
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// runRender processes a request offline like the listener does, but the PipelineRun is only
+// rendered. It prints the when results and the PipelineRun. It returns the exit code
+func runRender(args []string) int {
+	var headers headerFlags
+
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+
+	configFile := fs.String("config", "", "configuration file, ConfigMap manifest or raw configuration (mandatory)")
+	providerName := fs.String("provider", "github", "provider that sends the request (github, gitlab, bitbucket, gitea or cloudevents)")
+	payloadFile := fs.String("payload", "", "file with the payload of the request")
+	query := fs.String("query", "", "query string of the request, e.g. pipeline=microservice&prefix=myrepo")
+	namespace := fs.String("namespace", "default", "namespace of the PipelineRun if PIPELINES_NAMESPACE is not set")
+	fs.Var(&headers, "header", "http header of the request with the format 'Name: value' (can be repeated)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if len(*configFile) == 0 {
+		fmt.Fprintln(os.Stderr, "flag -config is mandatory")
+		fs.Usage()
+
+		return 2
+	}
+
+	// Only the result is written to stdout
+	utils.SetLogOutput(os.Stderr)
+
+	if len(os.Getenv("PIPELINES_NAMESPACE")) == 0 {
+		os.Setenv("PIPELINES_NAMESPACE", *namespace)
+	}
+
+	data, err := ioutil.ReadFile(*configFile)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	err = config.LoadConfigFromData(data)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %s\n", err.Error())
+		return 1
+	}
+
+	var provider webhook.Provider
+
+	for _, p := range newProviders(false) {
+		if p.Name() == *providerName {
+			provider = p
+			break
+		}
+	}
+
+	if provider == nil {
+		fmt.Fprintf(os.Stderr, "unknown provider %s\n", *providerName)
+		return 2
+	}
+
+	var payload []byte
+
+	if len(*payloadFile) > 0 {
+		payload, err = ioutil.ReadFile(*payloadFile)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	r, err := http.NewRequest("POST", fmt.Sprintf("/api/v1/%s?%s", provider.Name(), *query), bytes.NewReader(payload))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	for _, h := range headers {
+		kv := strings.SplitN(h, ":", 2)
+
+		if len(kv) != 2 {
+			fmt.Fprintf(os.Stderr, "malformed header %s\n", h)
+			return 2
+		}
+
+		r.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	event, err := provider.Event(r)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	id, err := utils.GenId()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	deliveryId := provider.DeliveryId(r)
+
+	// Provider.Event could read the body (e.g. structured CloudEvents)
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	req := &webhook.Request{
+		ID:          id,
+		Provider:    provider,
+		HttpRequest: r,
+		Payload:     body,
+		Event:       event,
+		DeliveryId:  deliveryId,
+		DryRun:      true,
+	}
+
+	out, err := yaml.Marshal(req.HandleRequest(context.Background()))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	fmt.Print(string(out))
+
+	return 0
+}
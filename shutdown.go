@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/queue"
+	"github.com/jaberchez/custom-tekton-listener/pkg/utils"
+)
+
+const (
+	defaultShutdownDelay time.Duration = 5 * time.Second
+	defaultDrainTimeout  time.Duration = 20 * time.Second
+)
+
+var (
+	// Requests processed in background
+	workers *queue.Pool
+
+	// Set to 1 when the server is shutting down
+	draining int32
+)
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+func readinessHealthCheck(w http.ResponseWriter, r *http.Request) {
+	if isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Listener is shutting down")
+
+		return
+	}
+
+	healthCheck(w, r)
+}
+
+// handleShutdown waits for SIGTERM or SIGINT and shuts down the server gracefully:
+//
+//  1. The readiness probe fails, and the server keeps serving during delay so that the pod
+//     is removed from the endpoints of the Service
+//  2. The server stops accepting connections and waits for the active requests
+//  3. It waits for the requests processed in background (PipelineRun creation)
+//
+// The steps 2 and 3 are limited to drainTimeout. done is closed when the shutdown finishes
+func handleShutdown(srv *http.Server, delay time.Duration, drainTimeout time.Duration, done chan<- struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-sigCh
+
+	utils.Log("INFO", fmt.Sprintf("received signal %s, shutting down", sig.String()))
+
+	atomic.StoreInt32(&draining, 1)
+
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+
+	if err != nil {
+		utils.Log("ERROR", fmt.Sprintf("unable to close active connections: %s", err.Error()))
+	}
+
+	err = workers.Close(ctx)
+
+	if err != nil {
+		utils.Log("ERROR", fmt.Sprintf("drain timeout exceeded, %s", err.Error()))
+	} else {
+		utils.Log("INFO", "all requests drained")
+	}
+
+	close(done)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jaberchez/custom-tekton-listener/pkg/config"
+)
+
+// runValidate checks configuration files offline. Each file can be the ConfigMap manifest or
+// the raw configuration. It returns the exit code
+func runValidate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s validate <file> [<file> ...]\n", os.Args[0])
+		return 2
+	}
+
+	var totalErrors int
+
+	for _, file := range args {
+		data, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+			totalErrors++
+
+			continue
+		}
+
+		errs := config.ValidateConfig(data)
+
+		for _, e := range errs {
+			fmt.Printf("%s: %s\n", file, e.Error())
+		}
+
+		if len(errs) == 0 {
+			fmt.Printf("%s: ok\n", file)
+		}
+
+		totalErrors += len(errs)
+	}
+
+	if totalErrors > 0 {
+		fmt.Printf("found %d error(s)\n", totalErrors)
+		return 1
+	}
+
+	return 0
+}